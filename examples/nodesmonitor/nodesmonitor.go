@@ -19,7 +19,7 @@ var (
 )
 
 func init() {
-	SchemeBuilder.Register(&NodesMonitor{})
+	SchemeBuilder.Register(&NodesMonitor{}, &NodesMonitorList{})
 }
 
 type NodesMonitor struct {
@@ -30,6 +30,13 @@ type NodesMonitor struct {
 	Status NodesMonitorStatus `json:"status,omitempty"`
 }
 
+type NodesMonitorList struct {
+	v1.TypeMeta `json:",inline"`
+	v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NodesMonitor `json:"items"`
+}
+
 type NodesMonitorSpec struct {
 	// Active indicates whether the monitor must be enabled or not.
 	Active bool `json:"active"`
@@ -51,6 +58,9 @@ type NodesMonitorStatus struct {
 
 	// History of the threshold changes.
 	Conditions []v1.Condition `json:"conditions,omitempty"`
+
+	// The generation of the resource last counted.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
 
 func (in *NodesMonitor) GetObjectKind() schema.ObjectKind {
@@ -83,12 +93,33 @@ func (in *NodesMonitor) DeepCopyInto(out *NodesMonitor) {
 	out.Status = *in.Status.DeepCopy()
 }
 
-func (nms *NodesMonitorStatus) AddThresholdExceededCondition(thresholdExceeded bool) {
+func (in *NodesMonitorList) GetObjectKind() schema.ObjectKind {
+	return &in.TypeMeta
+}
+
+func (in *NodesMonitorList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(NodesMonitorList)
+	*out = *in
+	out.ListMeta = *in.ListMeta.DeepCopy()
+	if in.Items != nil {
+		out.Items = make([]NodesMonitor, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+func (nms *NodesMonitorStatus) AddThresholdExceededCondition(thresholdExceeded bool, generation int64) {
 	newCond := v1.Condition{
 		Type:               "ThresholdExceeded",
 		Status:             v1.ConditionFalse,
 		Message:            "Nodes count is below the threshold.",
 		LastTransitionTime: v1.Now(),
+		ObservedGeneration: generation,
 	}
 	if thresholdExceeded {
 		newCond.Status = v1.ConditionTrue
@@ -116,6 +147,7 @@ func (in *NodesMonitorStatus) DeepCopy() *NodesMonitorStatus {
 	out := new(NodesMonitorStatus)
 	out.NumNodes = in.NumNodes
 	out.Active = in.Active
+	out.ObservedGeneration = in.ObservedGeneration
 	for _, c := range in.Conditions {
 		out.Conditions = append(out.Conditions, v1.Condition{
 			Type:               c.Type,
@@ -123,6 +155,7 @@ func (in *NodesMonitorStatus) DeepCopy() *NodesMonitorStatus {
 			Reason:             c.Reason,
 			Message:            c.Message,
 			LastTransitionTime: *c.LastTransitionTime.DeepCopy(),
+			ObservedGeneration: c.ObservedGeneration,
 		})
 	}
 	return out
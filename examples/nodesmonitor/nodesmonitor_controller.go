@@ -11,6 +11,8 @@ import (
 	"k8s.io/apimachinery/pkg/selection"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type NodesMonitorController struct {
@@ -40,10 +42,11 @@ func (c NodesMonitorController) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
-		// The current number of nodes changed, so let's update accordingly the resource status.
-		if len(nodes) != nm.Status.NumNodes {
+		// The current number of nodes (or the spec) changed, so let's update accordingly the resource status.
+		if len(nodes) != nm.Status.NumNodes || nm.Generation != nm.Status.ObservedGeneration {
 			nm.Status.NumNodes = len(nodes)
-			nm.Status.AddThresholdExceededCondition(nm.Status.NumNodes >= nm.Spec.AlertThreshold)
+			nm.Status.ObservedGeneration = nm.Generation
+			nm.Status.AddThresholdExceededCondition(nm.Status.NumNodes >= nm.Spec.AlertThreshold, nm.Generation)
 
 			if err := c.Status().Update(ctx, nm); err != nil {
 				return ctrl.Result{}, err
@@ -55,6 +58,30 @@ func (c NodesMonitorController) Reconcile(ctx context.Context, req ctrl.Request)
 	return ctrl.Result{RequeueAfter: time.Minute}, nil
 }
 
+// SetupWithManager registers the controller, so that it will be triggered
+// both by the NodesMonitor resources and by any node change.
+func (c NodesMonitorController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&NodesMonitor{}).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(c.requestsForNode)).
+		Complete(c)
+}
+
+// requestsForNode maps a node event to all the NodesMonitor resources, since
+// any of them could be interested in counting it.
+func (c NodesMonitorController) requestsForNode(ctx context.Context, node client.Object) []reconcile.Request {
+	nms := &NodesMonitorList{}
+	if err := c.List(ctx, nms); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, nm := range nms.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nm)})
+	}
+	return requests
+}
+
 func (c NodesMonitorController) listFilteredNodes(ctx context.Context, nm *NodesMonitor) ([]corev1.Node, error) {
 	nodeList := &corev1.NodeList{}
 	listOptions := &client.ListOptions{}
@@ -2,12 +2,20 @@ package nodesmonitor
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/andfasano/epistatest/pkg/conditions"
 	"github.com/andfasano/epistatest/pkg/epistatest"
 )
 
@@ -16,6 +24,8 @@ const (
 )
 
 func TestNodesMonitorController(t *testing.T) {
+	history := conditions.NewHistory()
+
 	cases := []struct {
 		name     string
 		testCase epistatest.Testable
@@ -84,6 +94,7 @@ func TestNodesMonitorController(t *testing.T) {
 				Then(func(client client.Client, obj *NodesMonitor) {
 					client.Create(context.Background(), Node("control-plane-4").Label("node-role.kubernetes.io/control-plane").Object())
 				}, "add a new node to reach the threshold").
+				AdvanceTime(time.Minute).
 				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
 					return obj.Status.NumNodes == 4 &&
 						obj.Status.Conditions[1].Status == v1.ConditionTrue
@@ -107,6 +118,7 @@ func TestNodesMonitorController(t *testing.T) {
 				Then(func(client client.Client, obj *NodesMonitor) {
 					client.Delete(context.Background(), Node("control-plane-0").Object())
 				}, "remove one node to decrease the counter below the threshold").
+				AdvanceTime(time.Minute).
 				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
 					cond := obj.Status.GetLatestCondition()
 					return obj.Status.NumNodes == 2 &&
@@ -129,12 +141,266 @@ func TestNodesMonitorController(t *testing.T) {
 				Then(func(client client.Client, obj *NodesMonitor) {
 					client.Create(context.Background(), Node("control-plane-4").Label("node-role.kubernetes.io/control-plane").Object())
 				}, "add another control-plane node, still above the threshold").
+				AdvanceTime(time.Minute).
 				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
 					return obj.Status.NumNodes == 4 &&
 						obj.Status.GetLatestCondition().Status == v1.ConditionTrue &&
 						len(obj.Status.Conditions) == 1
 				}, "verify the counter was updated, but not the conditions"),
 		},
+		{
+			name: "requeue after activation and resync",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				Setup(
+					Node("node-0"),
+					NodesMonitorObject("nodes-counter", testNS).Active()).
+				NextRequest("nodes-counter", testNS).
+				ExpectResult(ctrl.Result{Requeue: true}, "the monitor is activated").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}, "the nodes are counted and a resync is scheduled"),
+		},
+		{
+			name: "nodes are recounted only after the resync period",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				Setup(
+					SetupHelper().ControlPlanes(3),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane")).
+				NextRequest("control-plane-counter", testNS).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 3
+				}, "wait for the initial count").
+				Then(func(client client.Client, obj *NodesMonitor) {
+					client.Create(context.Background(), Node("control-plane-4").Label("node-role.kubernetes.io/control-plane").Object())
+				}, "add a new control-plane node").
+				AdvanceTime(59*time.Second).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 3
+				}, "nothing happens before the resync period").
+				AdvanceTime(time.Second).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 4
+				}, "the new node is counted after one minute"),
+		},
+		{
+			name: "new nodes are immediately counted when watched",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				For(&NodesMonitor{}).
+				Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+					return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "control-plane-counter", Namespace: testNS}}}
+				})).
+				Setup(
+					SetupHelper().ControlPlanes(3),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane")).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 3
+				}, "the monitor is automatically reconciled").
+				Then(func(client client.Client, obj *NodesMonitor) {
+					client.Create(context.Background(), Node("control-plane-4").Label("node-role.kubernetes.io/control-plane").Object())
+				}, "add a new control-plane node").
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 4
+				}, "the new node is counted without waiting the resync period"),
+		},
+		{
+			name: "watches from the controller setup",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				FromSetupWithManager(NodesMonitorController.SetupWithManager).
+				Setup(
+					SetupHelper().ControlPlanes(3).Workers(2),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane"),
+					NodesMonitorObject("worker-counter").Active().Filter("node-role.kubernetes.io/worker")).
+				NextRequest("worker-counter", testNS).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 2
+				}, "wait for the initial workers count").
+				Then(func(client client.Client, obj *NodesMonitor) {
+					client.Create(context.Background(), Node("worker-2").Label("node-role.kubernetes.io/worker").Object())
+				}, "add a new worker node").
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 3
+				}, "the new worker is immediately counted"),
+		},
+		{
+			name: "settle after activation",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				FromSetupWithManager(NodesMonitorController.SetupWithManager).
+				Setup(
+					Node("node-0"),
+					NodesMonitorObject("nodes-counter", testNS).Active()).
+				NextRequest("nodes-counter", testNS).
+				ReconcileUntilIdle("wait for the controller to settle").
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.Active && obj.Status.NumNodes == 1
+				}, "the monitor is active and the node counted"),
+		},
+		{
+			name: "converge after transient status update failures",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				FailOn(epistatest.VerbStatusUpdate, GroupVersion.WithKind("NodesMonitor"), 1, epistatest.ConflictFault()).
+				FailOn(epistatest.VerbStatusUpdate, GroupVersion.WithKind("NodesMonitor"), 2, epistatest.TimeoutFault()).
+				Setup(
+					Node("node-0"),
+					NodesMonitorObject("nodes-counter", testNS).Active()).
+				NextRequest("nodes-counter", testNS).
+				ExpectError(errors.IsConflict, "the activation fails with a conflict").
+				ExpectError(errors.IsTimeout, "the activation retry times out").
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.Active && obj.Status.NumNodes == 1
+				}, "the monitor eventually converges"),
+		},
+		{
+			name: "converge after a failure on any call",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				FromSetupWithManager(NodesMonitorController.SetupWithManager).
+				Setup(
+					SetupHelper().ControlPlanes(3).Workers(2),
+					NodesMonitorObject("worker-counter").Active().Filter("node-role.kubernetes.io/worker").AlertThreshold(2)).
+				NextRequest("worker-counter", testNS).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 2 && obj.Status.GetLatestCondition() != nil
+				}, "the workers are counted and the threshold is exceeded").
+				FaultCampaign(epistatest.ConflictFault()),
+		},
+		{
+			name: "no writes on resync without changes",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				Setup(
+					SetupHelper().ControlPlanes(3),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane")).
+				NextRequest("control-plane-counter", testNS).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 3
+				}, "wait for the initial count").
+				ExpectCalls(epistatest.VerbStatusUpdate, GroupVersion.WithKind("NodesMonitor"), 2).
+				AdvanceTime(time.Minute).
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}, "resync").
+				ExpectNoWrites(),
+		},
+		{
+			name: "idempotent once converged",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				WithIdempotencyCheck().
+				Setup(
+					SetupHelper().ControlPlanes(3),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane").AlertThreshold(2)).
+				NextRequest("control-plane-counter", testNS).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 3 && obj.Status.GetLatestCondition().Status == v1.ConditionTrue
+				}, "no further writes once the threshold is exceeded"),
+		},
+		{
+			name: "counters are always consistent",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				WithInvariant("nodes count is never negative", func(c client.Client) error {
+					nms := &NodesMonitorList{}
+					if err := c.List(context.Background(), nms); err != nil {
+						return err
+					}
+					for _, nm := range nms.Items {
+						if nm.Status.NumNodes < 0 {
+							return fmt.Errorf("%s has a negative count", nm.Name)
+						}
+					}
+					return nil
+				}).
+				Setup(
+					SetupHelper().ControlPlanes(3).Workers(1),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane").AlertThreshold(3)).
+				NextRequest("control-plane-counter", testNS).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 3
+				}, "wait for the initial count").
+				Never(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes > 3
+				}, "workers are never counted").
+				Then(func(client client.Client, obj *NodesMonitor) {
+					client.Delete(context.Background(), Node("control-plane-0").Object())
+				}, "remove one node").
+				AdvanceTime(time.Minute).
+				ReconcileUntil(func(client client.Client, obj *NodesMonitor) bool {
+					return obj.Status.NumNodes == 2
+				}, "the node removal is counted").
+				Always(func(client client.Client, obj *NodesMonitor) bool {
+					return len(obj.Status.Conditions) <= 2
+				}, "a single condition is added when crossing the threshold"),
+		},
+		{
+			name: "explain why the threshold condition was not reached",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				Setup(
+					SetupHelper().ControlPlanes(3).Workers(1),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane").AlertThreshold(4)).
+				NextRequest("control-plane-counter", testNS).
+				ReconcileUntilMatch(epistatest.HasCondition("ThresholdExceeded", v1.ConditionFalse), "below the threshold").
+				Then(func(client client.Client, obj *NodesMonitor) {
+					client.Create(context.Background(), Node("control-plane-4").Label("node-role.kubernetes.io/control-plane").Object())
+				}, "add a new node to reach the threshold").
+				AdvanceTime(time.Minute).
+				ReconcileUntilE(func(client client.Client, obj *NodesMonitor) error {
+					if obj.Status.NumNodes != 4 {
+						return fmt.Errorf("found %d nodes", obj.Status.NumNodes)
+					}
+					return epistatest.HasCondition("ThresholdExceeded", v1.ConditionTrue)(obj)
+				}, "the threshold alert has been triggered"),
+		},
+		{
+			name: "threshold condition transitions",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				WithConditionHistory(history).
+				Setup(
+					SetupHelper().ControlPlanes(3).Workers(1),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane").AlertThreshold(4)).
+				NextRequest("control-plane-counter", testNS).
+				ReconcileUntil(conditions.HasCondition[*NodesMonitor]("ThresholdExceeded", v1.ConditionFalse, ""), "below the threshold").
+				Then(func(client client.Client, obj *NodesMonitor) {
+					client.Create(context.Background(), Node("control-plane-4").Label("node-role.kubernetes.io/control-plane").Object())
+				}, "add a new node to reach the threshold").
+				AdvanceTime(time.Minute).
+				ReconcileUntil(conditions.HasCondition[*NodesMonitor]("ThresholdExceeded", v1.ConditionTrue, ""), "over the threshold").
+				Then(func(client client.Client, obj *NodesMonitor) {
+					client.Delete(context.Background(), Node("control-plane-0").Object())
+				}, "remove one node to fall back below the threshold").
+				AdvanceTime(time.Minute).
+				ReconcileUntilE(func(client client.Client, obj *NodesMonitor) error {
+					if err := history.ExpectSequence(types.NamespacedName{Name: "control-plane-counter", Namespace: testNS}, "ThresholdExceeded",
+						v1.ConditionFalse, v1.ConditionTrue, v1.ConditionFalse); err != nil {
+						return err
+					}
+					return history.ExpectObservedGeneration()
+				}, "conditions went through all the transitions"),
+		},
+		{
+			name: "spec changes are observed",
+			testCase: epistatest.New[NodesMonitorController, *NodesMonitor]().
+				WithSchemes(AddToScheme, corev1.AddToScheme).
+				Setup(
+					SetupHelper().ControlPlanes(3).Workers(1),
+					NodesMonitorObject("control-plane-counter").Active().Filter("node-role.kubernetes.io/control-plane").AlertThreshold(4)).
+				NextRequest("control-plane-counter", testNS).
+				ExpectObservedGenerationCurrent("initial spec observed").
+				Then(func(client client.Client, obj *NodesMonitor) {
+					obj.Spec.AlertThreshold = 3
+					client.Update(context.Background(), obj)
+				}, "lower the threshold").
+				ExpectObservedGenerationCurrent("new spec observed").
+				ReconcileUntilE(func(client client.Client, obj *NodesMonitor) error {
+					if obj.Generation != 2 {
+						return fmt.Errorf("found generation %d", obj.Generation)
+					}
+					return epistatest.HasCondition("ThresholdExceeded", v1.ConditionTrue)(obj)
+				}, "the threshold alert has been triggered"),
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, tc.testCase.Test)
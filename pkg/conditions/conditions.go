@@ -0,0 +1,102 @@
+// Package conditions provides helpers for verifying the conditions reported
+// by an object in its status, and for tracking their transitions.
+package conditions
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getter is implemented by the objects directly exposing their conditions.
+type getter interface {
+	GetConditions() []metav1.Condition
+}
+
+// Get extracts the conditions from the status.conditions field of the object.
+// Objects implementing GetConditions() are directly queried, otherwise their
+// unstructured content is used, so that also conditions of a different type (like
+// the corev1.NodeCondition) are returned.
+func Get(obj runtime.Object) ([]metav1.Condition, error) {
+	if g, ok := obj.(getter); ok {
+		return g.GetConditions(), nil
+	}
+
+	content, ok := obj.(runtime.Unstructured)
+	var fields map[string]any
+	if ok {
+		fields = content.UnstructuredContent()
+	} else {
+		var err error
+		if fields, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			return nil, err
+		}
+	}
+
+	items, _, err := unstructured.NestedSlice(fields, "status", "conditions")
+	if err != nil {
+		return nil, err
+	}
+	conditions := make([]metav1.Condition, 0, len(items))
+	for _, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unexpected condition %v", item)
+		}
+		var condition metav1.Condition
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(fields, &condition); err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+// Find returns the condition of the given type, or nil if not found. If the same
+// type is reported more than once, the latest one is returned.
+func Find(obj runtime.Object, conditionType string) (*metav1.Condition, error) {
+	conditions, err := Get(obj)
+	if err != nil {
+		return nil, err
+	}
+	var found *metav1.Condition
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			found = &conditions[i]
+		}
+	}
+	return found, nil
+}
+
+// Check verifies that the object reports a condition of the given type and status
+// and, if not empty, reason. The returned error explains the mismatch.
+func Check(obj runtime.Object, conditionType string, status metav1.ConditionStatus, reason string) error {
+	condition, err := Find(obj, conditionType)
+	if err != nil {
+		return err
+	}
+	if condition == nil {
+		return fmt.Errorf("condition `%s` not found", conditionType)
+	}
+	if condition.Status != status {
+		if condition.Message != "" {
+			return fmt.Errorf("condition `%s` is `%s` (%s), expected `%s`", conditionType, condition.Status, condition.Message, status)
+		}
+		return fmt.Errorf("condition `%s` is `%s`, expected `%s`", conditionType, condition.Status, status)
+	}
+	if reason != "" && condition.Reason != reason {
+		return fmt.Errorf("condition `%s` reason is `%s`, expected `%s`", conditionType, condition.Reason, reason)
+	}
+	return nil
+}
+
+// HasCondition returns a predicate, usable in ReconcileUntil, satisfied when the
+// object reports a condition of the given type and status and, if not empty, reason.
+func HasCondition[T client.Object](conditionType string, status metav1.ConditionStatus, reason string) func(c client.Client, obj T) bool {
+	return func(_ client.Client, obj T) bool {
+		return Check(obj, conditionType, status, reason) == nil
+	}
+}
@@ -0,0 +1,73 @@
+package conditions
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type objectWithConditions struct {
+	corev1.ConfigMap
+	conditions []metav1.Condition
+}
+
+func (o *objectWithConditions) GetConditions() []metav1.Condition {
+	return o.conditions
+}
+
+func TestCheck(t *testing.T) {
+	node := &corev1.Node{
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Reason: "KubeletNotReady", Message: "kubelet stopped"},
+				{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue, Reason: "KubeletReady"},
+			},
+		},
+	}
+	withConditions := &objectWithConditions{
+		conditions: []metav1.Condition{
+			{Type: "Available", Status: metav1.ConditionFalse, Reason: "Progressing"},
+		},
+	}
+	unstructuredObj := &unstructured.Unstructured{Object: map[string]any{
+		"status": map[string]any{
+			"conditions": []any{
+				map[string]any{"type": "Available", "status": "True", "reason": "Deployed"},
+			},
+		},
+	}}
+
+	cases := []struct {
+		name          string
+		obj           runtime.Object
+		conditionType string
+		status        metav1.ConditionStatus
+		reason        string
+		expectedError string
+	}{
+		{name: "latest condition of the type", obj: node, conditionType: "Ready", status: metav1.ConditionTrue},
+		{name: "reason", obj: node, conditionType: "Ready", status: metav1.ConditionTrue, reason: "KubeletReady"},
+		{name: "wrong reason", obj: node, conditionType: "Ready", status: metav1.ConditionTrue, reason: "KubeletNotReady", expectedError: "condition `Ready` reason is `KubeletReady`, expected `KubeletNotReady`"},
+		{name: "wrong status", obj: node, conditionType: "MemoryPressure", status: metav1.ConditionTrue, expectedError: "condition `MemoryPressure` is `False`, expected `True`"},
+		{name: "missing condition", obj: node, conditionType: "DiskPressure", status: metav1.ConditionFalse, expectedError: "condition `DiskPressure` not found"},
+		{name: "conditions getter", obj: withConditions, conditionType: "Available", status: metav1.ConditionFalse, reason: "Progressing"},
+		{name: "unstructured", obj: unstructuredObj, conditionType: "Available", status: metav1.ConditionTrue, reason: "Deployed"},
+		{name: "no conditions", obj: &corev1.ConfigMap{}, conditionType: "Available", status: metav1.ConditionTrue, expectedError: "condition `Available` not found"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Check(tc.obj, tc.conditionType, tc.status, tc.reason)
+			if err == nil && tc.expectedError != "" {
+				t.Fatalf("expecting error `%s` but none received", tc.expectedError)
+			}
+			if err != nil && err.Error() != tc.expectedError {
+				t.Fatalf("expected error: `%s`, but received `%s", tc.expectedError, err.Error())
+			}
+		})
+	}
+}
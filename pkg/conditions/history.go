@@ -0,0 +1,174 @@
+package conditions
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Transition is a change of a condition status observed after a reconcile.
+type Transition struct {
+	Object             client.ObjectKey       // object reporting the condition
+	Type               string                 // condition type
+	From               metav1.ConditionStatus // previous status, empty when the condition first appeared
+	To                 metav1.ConditionStatus // new status, empty when the condition was removed
+	Reason             string                 // new condition reason
+	Message            string                 // new condition message
+	ObservedGeneration int64                  // generation observed by the condition
+	Generation         int64                  // object generation when the transition was observed
+	Reconcile          int                    // reconcile after which the transition was observed
+}
+
+func (t Transition) String() string {
+	return fmt.Sprintf("#%d %s %s: %s -> %s (%s)", t.Reconcile, t.Object, t.Type, formatStatus(t.From), formatStatus(t.To), t.Reason)
+}
+
+// Removed returns true if the transition is the removal of the condition.
+func (t Transition) Removed() bool {
+	return t.To == ""
+}
+
+// History records the conditions transitions observed across reconciles.
+type History struct {
+	transitions []Transition
+	latest      map[client.ObjectKey]map[string]metav1.ConditionStatus
+}
+
+// NewHistory returns an empty history.
+func NewHistory() *History {
+	h := &History{}
+	h.Reset()
+	return h
+}
+
+// Reset discards all the recorded transitions.
+func (h *History) Reset() {
+	h.transitions = nil
+	h.latest = map[client.ObjectKey]map[string]metav1.ConditionStatus{}
+}
+
+// Observe records a transition for every condition of the object whose status
+// changed since the previous observation, and for every condition removed.
+func (h *History) Observe(reconcile int, obj client.Object) error {
+	conditions, err := Get(obj)
+	if err != nil {
+		return err
+	}
+
+	key := client.ObjectKeyFromObject(obj)
+	if h.latest[key] == nil {
+		h.latest[key] = map[string]metav1.ConditionStatus{}
+	}
+
+	// If the same type is reported more than once, only the latest one is used.
+	latest := map[string]metav1.Condition{}
+	var types []string
+	for _, c := range conditions {
+		if _, found := latest[c.Type]; !found {
+			types = append(types, c.Type)
+		}
+		latest[c.Type] = c
+	}
+	for _, t := range types {
+		c := latest[t]
+		from := h.latest[key][t]
+		if from == c.Status {
+			continue
+		}
+		h.latest[key][t] = c.Status
+		h.transitions = append(h.transitions, Transition{
+			Object:             key,
+			Type:               t,
+			From:               from,
+			To:                 c.Status,
+			Reason:             c.Reason,
+			Message:            c.Message,
+			ObservedGeneration: c.ObservedGeneration,
+			Generation:         obj.GetGeneration(),
+			Reconcile:          reconcile,
+		})
+	}
+
+	var removed []string
+	for t := range h.latest[key] {
+		if _, found := latest[t]; !found {
+			removed = append(removed, t)
+		}
+	}
+	slices.Sort(removed)
+	for _, t := range removed {
+		h.transitions = append(h.transitions, Transition{
+			Object:     key,
+			Type:       t,
+			From:       h.latest[key][t],
+			Generation: obj.GetGeneration(),
+			Reconcile:  reconcile,
+		})
+		delete(h.latest[key], t)
+	}
+	return nil
+}
+
+// Transitions returns all the recorded transitions, in the order they were observed.
+func (h *History) Transitions() []Transition {
+	return append([]Transition{}, h.transitions...)
+}
+
+// Statuses returns the sequence of statuses observed for the given object condition,
+// where an empty status marks the removal of the condition.
+func (h *History) Statuses(key client.ObjectKey, conditionType string) []metav1.ConditionStatus {
+	var statuses []metav1.ConditionStatus
+	for _, t := range h.transitions {
+		if t.Object == key && t.Type == conditionType {
+			statuses = append(statuses, t.To)
+		}
+	}
+	return statuses
+}
+
+// ExpectSequence verifies that the given object condition went exactly through
+// the specified statuses, in order.
+func (h *History) ExpectSequence(key client.ObjectKey, conditionType string, statuses ...metav1.ConditionStatus) error {
+	observed := h.Statuses(key, conditionType)
+	if !slices.Equal(observed, statuses) {
+		return fmt.Errorf("expected `%s` transitions %s for %s but found %s", conditionType, formatStatuses(statuses), key, formatStatuses(observed))
+	}
+	return nil
+}
+
+// ExpectObservedGeneration verifies that every recorded transition reported the
+// generation of the object at the time it was observed. The removals are ignored.
+func (h *History) ExpectObservedGeneration() error {
+	var stale []string
+	for _, t := range h.transitions {
+		if !t.Removed() && t.ObservedGeneration != t.Generation {
+			stale = append(stale, fmt.Sprintf("\n  %s observed generation %d, expected %d", t, t.ObservedGeneration, t.Generation))
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("found %d transitions with a stale observed generation%s", len(stale), strings.Join(stale, ""))
+	}
+	return nil
+}
+
+func formatStatuses(statuses []metav1.ConditionStatus) string {
+	if len(statuses) == 0 {
+		return "none"
+	}
+	s := make([]string, len(statuses))
+	for i, status := range statuses {
+		s[i] = formatStatus(status)
+	}
+	return strings.Join(s, "->")
+}
+
+// formatStatus describes a missing condition status as none.
+func formatStatus(status metav1.ConditionStatus) string {
+	if status == "" {
+		return "none"
+	}
+	return string(status)
+}
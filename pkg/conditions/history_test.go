@@ -0,0 +1,119 @@
+package conditions
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func withReady(generation int64, conditions ...metav1.Condition) *objectWithConditions {
+	obj := &objectWithConditions{conditions: conditions}
+	obj.Name = "obj"
+	obj.Namespace = "ns"
+	obj.Generation = generation
+	return obj
+}
+
+func ready(status metav1.ConditionStatus, observedGeneration int64) metav1.Condition {
+	return metav1.Condition{Type: "Ready", Status: status, ObservedGeneration: observedGeneration}
+}
+
+func TestHistory(t *testing.T) {
+	key := client.ObjectKey{Name: "obj", Namespace: "ns"}
+
+	h := NewHistory()
+	for i, obj := range []client.Object{
+		withReady(1),
+		withReady(1, ready(metav1.ConditionFalse, 1)),
+		withReady(1, ready(metav1.ConditionFalse, 1)),
+		withReady(2, ready(metav1.ConditionTrue, 1)),
+		withReady(2, ready(metav1.ConditionFalse, 1), ready(metav1.ConditionFalse, 2)),
+	} {
+		if err := h.Observe(i+1, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := h.ExpectSequence(key, "Ready", metav1.ConditionFalse, metav1.ConditionTrue, metav1.ConditionFalse); err != nil {
+		t.Fatal(err)
+	}
+	err := h.ExpectSequence(key, "Ready", metav1.ConditionFalse, metav1.ConditionTrue)
+	if err == nil || err.Error() != "expected `Ready` transitions False->True for ns/obj but found False->True->False" {
+		t.Fatalf("unexpected error `%v`", err)
+	}
+	err = h.ExpectSequence(client.ObjectKey{Name: "other", Namespace: "ns"}, "Ready", metav1.ConditionTrue)
+	if err == nil || err.Error() != "expected `Ready` transitions True for ns/other but found none" {
+		t.Fatalf("unexpected error `%v`", err)
+	}
+
+	err = h.ExpectObservedGeneration()
+	if err == nil || err.Error() != "found 1 transitions with a stale observed generation\n  #4 ns/obj Ready: False -> True () observed generation 1, expected 2" {
+		t.Fatalf("unexpected error `%v`", err)
+	}
+
+	transitions := h.Transitions()
+	if len(transitions) != 3 || transitions[0].From != "" || transitions[2].Reconcile != 5 {
+		t.Fatalf("unexpected transitions %v", transitions)
+	}
+
+	h.Reset()
+	if len(h.Transitions()) != 0 {
+		t.Fatal("expected no transitions after reset")
+	}
+	if err := h.Observe(1, withReady(1, ready(metav1.ConditionTrue, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ExpectSequence(key, "Ready", metav1.ConditionTrue); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryRemovedCondition(t *testing.T) {
+	key := client.ObjectKey{Name: "obj", Namespace: "ns"}
+
+	h := NewHistory()
+	for i, obj := range []client.Object{
+		withReady(1, ready(metav1.ConditionTrue, 1)),
+		withReady(1),
+		withReady(1),
+		withReady(1, ready(metav1.ConditionTrue, 1)),
+	} {
+		if err := h.Observe(i+1, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := h.ExpectSequence(key, "Ready", metav1.ConditionTrue, "", metav1.ConditionTrue); err != nil {
+		t.Fatal(err)
+	}
+	transitions := h.Transitions()
+	if len(transitions) != 3 || !transitions[1].Removed() || transitions[2].From != "" {
+		t.Fatalf("unexpected transitions %v", transitions)
+	}
+	if s := transitions[1].String(); s != "#2 ns/obj Ready: True -> none ()" {
+		t.Fatalf("unexpected removal `%s`", s)
+	}
+	if err := h.ExpectObservedGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	err := h.ExpectSequence(key, "Ready", metav1.ConditionTrue)
+	if err == nil || err.Error() != "expected `Ready` transitions True for ns/obj but found True->none->True" {
+		t.Fatalf("unexpected error `%v`", err)
+	}
+}
+
+func TestHistoryNodeConditions(t *testing.T) {
+	node := &corev1.Node{}
+	node.Name = "node"
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+
+	h := NewHistory()
+	if err := h.Observe(1, node); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ExpectSequence(client.ObjectKey{Name: "node"}, "Ready", metav1.ConditionTrue); err != nil {
+		t.Fatal(err)
+	}
+}
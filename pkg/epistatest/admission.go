@@ -0,0 +1,161 @@
+package epistatest
+
+import (
+	"context"
+	"reflect"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// admissionChain admits the writes issued on the scenario cluster in the same order
+// of the API server: the schema defaulting, the mutating webhooks, the pruning of the
+// unknown fields, the schema validation (including the x-kubernetes-validations rules)
+// and finally the validating webhooks.
+type admissionChain struct {
+	crds     crdSchemas        // schemas of the loaded custom resources
+	webhooks admissionWebhooks // webhooks registered for every kind
+}
+
+// enabled returns true if any schema or webhook was registered.
+func (a admissionChain) enabled() bool {
+	return len(a.crds) > 0 || len(a.webhooks) > 0
+}
+
+// admit runs all the admission phases on the object, which is modified in place by the
+// defaulting and the pruning, and, when the status subresource is enabled, by resetting
+// the fields that the write cannot change. The old object is nil for a creation. The webhooks are not invoked for the
+// writes on a subresource, like the status.
+func (a admissionChain) admit(ctx context.Context, obj client.Object, old client.Object, subResource bool, scheme *runtime.Scheme) error {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return nil
+	}
+	webhooks := a.webhooks
+	if subResource || len(webhooks[gvk]) == 0 {
+		webhooks = nil
+	} else {
+		operation := admissionv1.Create
+		if old != nil {
+			operation = admissionv1.Update
+		}
+		ctx = admissionContext(ctx, gvk, obj, operation)
+	}
+
+	if err := a.crds.prepareUpdate(gvk, obj, old, subResource); err != nil {
+		return err
+	}
+	if err := a.crds.applyDefaults(gvk, obj); err != nil {
+		return err
+	}
+	if err := webhooks.mutate(ctx, gvk, obj); err != nil {
+		return err
+	}
+	if err := a.crds.prune(gvk, obj); err != nil {
+		return err
+	}
+	if err := a.crds.validate(ctx, gvk, obj, old); err != nil {
+		return err
+	}
+	return webhooks.validate(ctx, gvk, obj, old)
+}
+
+// withAdmission wraps the client so that every write is admitted by the admission chain
+// before being stored. A patch is previewed, to admit the patched object. The deletions
+// are admitted only by the validating webhooks.
+func withAdmission(c client.WithWatch, chain admissionChain) client.WithWatch {
+	admit := func(subResource bool) func(ctx context.Context, obj client.Object, old client.Object) error {
+		return func(ctx context.Context, obj client.Object, old client.Object) error {
+			return chain.admit(ctx, obj, old, subResource, c.Scheme())
+		}
+	}
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if err := admit(false)(ctx, obj, nil); err != nil {
+				return err
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if old := snapshot(ctx, c, obj); old != nil {
+				if err := admit(false)(ctx, obj, old); err != nil {
+					return err
+				}
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			patchOpts := &client.PatchOptions{}
+			patchOpts.ApplyOptions(opts)
+			return admitPatch(ctx, c, obj, patch, patchOpts, admit(false), func() error {
+				return c.Patch(ctx, obj, patch, opts...)
+			}, func(admitted client.Object) error {
+				return c.Update(ctx, admitted, patchUpdateOptions(patchOpts))
+			})
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if stored := snapshot(ctx, c, obj); stored != nil {
+				if err := chain.webhooks.admitDelete(ctx, stored, c.Scheme()); err != nil {
+					return err
+				}
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			if old := snapshot(ctx, c, obj); old != nil {
+				if err := admit(true)(ctx, obj, old); err != nil {
+					return err
+				}
+			}
+			return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			patchOpts := &client.SubResourcePatchOptions{}
+			patchOpts.ApplyOptions(opts)
+			return admitPatch(ctx, c, obj, patch, &patchOpts.PatchOptions, admit(true), func() error {
+				return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+			}, func(admitted client.Object) error {
+				return c.SubResource(subResourceName).Update(ctx, admitted, &client.SubResourceUpdateOptions{UpdateOptions: *patchUpdateOptions(&patchOpts.PatchOptions)})
+			})
+		},
+	})
+}
+
+// admitPatch admits the result of a patch before applying it. When the admission
+// changes the patched object, for example by defaulting it, the admitted object is
+// stored in place of the patch, so that a single write is issued like in the API server.
+// Nothing is written for a dry run, and obj is set to the admitted object.
+func admitPatch(ctx context.Context, c client.Client, obj client.Object, patch client.Patch, opts *client.PatchOptions, admit func(ctx context.Context, obj client.Object, old client.Object) error, write func() error, update func(admitted client.Object) error) error {
+	old := snapshot(ctx, c, obj)
+	if old == nil {
+		return write()
+	}
+	preview, err := applyPatch(old, obj, patch)
+	if err != nil {
+		return err
+	}
+	admitted := preview.DeepCopyObject().(client.Object)
+	if err := admit(ctx, admitted, old); err != nil {
+		return err
+	}
+	if len(opts.DryRun) > 0 {
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(admitted).Elem())
+		return nil
+	}
+	if equality.Semantic.DeepEqual(admitted, preview) {
+		return write()
+	}
+	if err := update(admitted); err != nil {
+		return err
+	}
+	return c.Get(ctx, client.ObjectKeyFromObject(admitted), obj)
+}
+
+// patchUpdateOptions returns the options of the update storing a patched object.
+func patchUpdateOptions(opts *client.PatchOptions) *client.UpdateOptions {
+	return &client.UpdateOptions{DryRun: opts.DryRun, FieldManager: opts.FieldManager}
+}
@@ -0,0 +1,117 @@
+package epistatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Call describes an API call issued by the reconciler.
+type Call struct {
+	// Verb is the operation requested.
+	Verb Verb
+	// GVK is the kind of the resource involved.
+	GVK schema.GroupVersionKind
+	// Key identifies the resource involved, empty for collection operations.
+	Key client.ObjectKey
+	// Body contains the json representation of the object sent, or the patch data.
+	Body []byte
+	// Step is the label of the step being executed.
+	Step string
+	// Reconcile is the index of the reconcile invocation (starting from 1) within
+	// the scenario.
+	Reconcile int
+	// Controller identifies the controller which issued the call, by its reconciler
+	// type (see WithController).
+	Controller string
+	// Injected is the fault returned instead of executing the call, if any (see FailOn).
+	Injected error
+}
+
+func (c Call) String() string {
+	if c.Key.Name == "" {
+		return fmt.Sprintf("%s %s", c.Verb, c.GVK.Kind)
+	}
+	return fmt.Sprintf("%s %s %s", c.Verb, c.GVK.Kind, c.Key)
+}
+
+// IsWrite returns true if the call was meant to modify a resource.
+func (c Call) IsWrite() bool {
+	return c.Verb != VerbGet && c.Verb != VerbList && !strings.HasPrefix(string(c.Verb), string(VerbGet)+"/")
+}
+
+func objectBody(obj runtime.Object) []byte {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+func patchBody(patch client.Patch, obj client.Object) []byte {
+	body, err := patch.Data(obj)
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+// callsCheck verifies the calls issued during a step.
+type callsCheck func(calls []Call) error
+
+// countCalls returns a check verifying the number of calls matching the
+// given verb and kind, where an empty verb or kind matches any verb or kind.
+func countCalls(verb Verb, gvk schema.GroupVersionKind, n int) callsCheck {
+	f := fault{verb: verb, gvk: gvk}
+	return func(calls []Call) error {
+		var found []Call
+		for _, c := range calls {
+			if f.matches(c.Verb, c.GVK) {
+				found = append(found, c)
+			}
+		}
+		if len(found) != n {
+			return fmt.Errorf("expected %d %s calls but found %d%s", n, describeCalls(verb, gvk), len(found), formatCalls(found))
+		}
+		return nil
+	}
+}
+
+// countWrites returns a check verifying the number of write calls.
+func countWrites(n int) callsCheck {
+	return func(calls []Call) error {
+		var writes []Call
+		for _, c := range calls {
+			if c.IsWrite() {
+				writes = append(writes, c)
+			}
+		}
+		if len(writes) != n {
+			return fmt.Errorf("expected %d writes but found %d%s", n, len(writes), formatCalls(writes))
+		}
+		return nil
+	}
+}
+
+func describeCalls(verb Verb, gvk schema.GroupVersionKind) string {
+	desc := string(verb)
+	if verb == "" {
+		desc = "any"
+	}
+	if !gvk.Empty() {
+		desc += " " + gvk.Kind
+	}
+	return desc
+}
+
+func formatCalls(calls []Call) string {
+	var sb strings.Builder
+	for _, c := range calls {
+		sb.WriteString(fmt.Sprintf("\n  #%d %s", c.Reconcile, c))
+	}
+	return sb.String()
+}
@@ -0,0 +1,147 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// TestControllerWithMapFunc creates a secret for every configmap, and it maps the
+// secrets events to all the configmaps using its own client.
+type TestControllerWithMapFunc struct {
+	client.Client
+}
+
+func (c TestControllerWithMapFunc) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: req.Name, Namespace: req.Namespace}}
+	return ctrl.Result{}, client.IgnoreAlreadyExists(c.Create(ctx, secret))
+}
+
+func (c TestControllerWithMapFunc) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.ConfigMap{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(c.requestsForSecret)).
+		Complete(c)
+}
+
+func (c TestControllerWithMapFunc) requestsForSecret(ctx context.Context, obj client.Object) []ctrl.Request {
+	cms := &corev1.ConfigMapList{}
+	if err := c.List(ctx, cms, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var requests []ctrl.Request
+	for _, cm := range cms.Items {
+		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&cm)})
+	}
+	return requests
+}
+
+func TestMapFuncCallsNotRecorded(t *testing.T) {
+	testScenario[TestControllerWithMapFunc, *corev1.ConfigMap](t, testCase{
+		testCase: New[TestControllerWithMapFunc, *corev1.ConfigMap]().
+			WithSchemes(corev1.AddToScheme).
+			FromSetupWithManager(TestControllerWithMapFunc.SetupWithManager).
+			FailOn(VerbCreate, corev1.SchemeGroupVersion.WithKind("Secret"), 2, TimeoutFault()).
+			Setup(testScenarioBuilder{}).
+			ReconcileUntilIdle().
+			ExpectCalls(VerbList, schema.GroupVersionKind{}, 0).
+			VerifyCalls(func(calls []Call) error {
+				if len(calls) < 2 || calls[1].Injected == nil {
+					return fmt.Errorf("fault not injected in the second secret creation: %v", calls)
+				}
+				return nil
+			}),
+	})
+}
+
+func TestRecordedCalls(t *testing.T) {
+	configMapGVK := corev1.SchemeGroupVersion.WithKind("ConfigMap")
+
+	cases := []testCase{
+		{
+			name: "expected calls",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}).
+				ExpectCalls(VerbGet, configMapGVK, 1).
+				ExpectCalls(VerbUpdate, configMapGVK, 1).
+				ExpectCalls(VerbUpdate, corev1.SchemeGroupVersion.WithKind("Secret"), 0).
+				ExpectWrites(1),
+		},
+		{
+			name: "unexpected calls",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}).
+				ExpectCalls("", schema.GroupVersionKind{}, 3),
+			expectedError: "`waiting condition #1` failure, expected 3 any calls but found 2\n  #1 get ConfigMap cm/cm0\n  #1 update ConfigMap cm/cm0",
+		},
+		{
+			name: "unexpected writes",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}).
+				AdvanceTime(time.Minute).
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}, "resync").
+				ExpectNoWrites(),
+			expectedError: "`resync` failure, expected 0 writes but found 1\n  #2 update ConfigMap cm/cm0",
+		},
+		{
+			name: "no writes",
+			testCase: newTestScenario().
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilIdle().
+				ExpectNoWrites(),
+		},
+		{
+			name: "verify calls",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}).
+				AdvanceTime(time.Minute).
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}, "resync").
+				VerifyCalls(func(calls []Call) error {
+					if len(calls) != 2 {
+						return fmt.Errorf("unexpected calls %v", calls)
+					}
+					update := calls[1]
+					if update.Step != "resync" || update.Reconcile != 2 || !update.IsWrite() {
+						return fmt.Errorf("unexpected update call %+v", update)
+					}
+					if !strings.Contains(string(update.Body), `"reconciles":"2"`) {
+						return fmt.Errorf("unexpected update body %s", update.Body)
+					}
+					return nil
+				}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			switch tc.testCase.(type) {
+			case *scenario[TestController, *corev1.ConfigMap]:
+				testScenario[TestController, *corev1.ConfigMap](t, tc)
+			default:
+				testScenario[TestControllerWithRequeueAfter, *corev1.ConfigMap](t, tc)
+			}
+		})
+	}
+}
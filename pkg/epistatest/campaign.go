@@ -0,0 +1,37 @@
+package epistatest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// runFaultCampaign runs the scenario once for every call issued by the reconciler
+// in a successful run, by making that call fail.
+func (s *scenario[R, T]) runFaultCampaign() error {
+	s.failCall = 0
+	if err := s.setupEnv(); err != nil {
+		return err
+	}
+	if err := s.run(); err != nil {
+		return fmt.Errorf("fault campaign, baseline run failure: %w", err)
+	}
+	calls := s.injector.recorded
+
+	var failures []string
+	for i, call := range calls {
+		s.failCall = i + 1
+		err := s.setupEnv()
+		if err == nil {
+			err = s.run()
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("call #%d (%s): %v", s.failCall, call, err))
+		}
+	}
+	s.failCall = 0
+
+	if len(failures) > 0 {
+		return fmt.Errorf("fault campaign, %d of %d faulty runs did not converge:\n%s", len(failures), len(calls), strings.Join(failures, "\n"))
+	}
+	return nil
+}
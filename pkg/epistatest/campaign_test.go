@@ -0,0 +1,93 @@
+package epistatest
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TestControllerIgnoringErrors marks the config map, but it does not
+// report any error to the caller.
+type TestControllerIgnoringErrors struct {
+	client.Client
+}
+
+func (s TestControllerIgnoringErrors) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cm := &corev1.ConfigMap{}
+	if err := s.Get(ctx, req.NamespacedName, cm); err != nil {
+		return ctrl.Result{}, nil
+	}
+	if cm.Data["marked"] == "" {
+		cm.Data = map[string]string{"marked": "true"}
+		_ = s.Update(ctx, cm)
+	}
+	return ctrl.Result{}, nil
+}
+
+func singleConfigMap() []client.Object {
+	return []client.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "cm0",
+				Namespace: "cm",
+			},
+		},
+	}
+}
+
+func TestFaultCampaign(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "converging",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				SetupObjects(singleConfigMap).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				FaultCampaign(nil),
+		},
+		{
+			name: "baseline failure",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				SetupObjects(singleConfigMap).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 2
+				}).
+				FaultCampaign(nil),
+			expectedError: "fault campaign, baseline run failure: `waiting condition #1` not satisfied, next reconcile scheduled in 1m0s",
+		},
+		{
+			name: "not converging",
+			testCase: New[TestControllerIgnoringErrors, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				For(&corev1.ConfigMap{}).
+				SetupObjects(singleConfigMap).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return obj.Data["marked"] == "true"
+				}, "marked").
+				FaultCampaign(ConflictFault()),
+			expectedError: "fault campaign, 2 of 2 faulty runs did not converge:\n" +
+				"call #1 (get ConfigMap cm/cm0): `marked` not satisfied, no pending reconcile requests\n" +
+				"call #2 (update ConfigMap cm/cm0): `marked` not satisfied, no pending reconcile requests",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			switch tc.testCase.(type) {
+			case *scenario[TestControllerWithRequeueAfter, *corev1.ConfigMap]:
+				testScenario[TestControllerWithRequeueAfter, *corev1.ConfigMap](t, tc)
+			default:
+				testScenario[TestControllerIgnoringErrors, *corev1.ConfigMap](t, tc)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// allControllers selects the global scheduler, reconciling the requests of all
+// the scenario controllers (see ReconcileAll).
+const allControllers = "*"
+
+// WithController registers an additional reconciler of type R2, running in the same
+// scenario cluster of the main one. The reconciler is created like the main one (its
+// fields are injected with the scenario dependencies), and then the setup is invoked to
+// capture its watches (see FromSetupWithManager), which will route the requests to its
+// own queue. The setup is usually specified with a method expression, ie:
+//
+//	WithController(s, SecretReconciler.SetupWithManager)
+//
+// The additional controllers are reconciled only by the steps following a ReconcileWith
+// or ReconcileAll, while the events keep being routed to their queues. With ReconcileAll,
+// a controller reconciles in its turn as many ready requests as its MaxConcurrentReconciles
+// option, like its concurrent workers would.
+func WithController[R2 reconcile.Reconciler, R reconcile.Reconciler, T client.Object](s Scenario[R, T], setup func(r R2, mgr manager.Manager) error) Scenario[R, T] {
+	sc := s.(*scenario[R, T])
+	sc.controllerSpecs = append(sc.controllerSpecs, controllerSpec{
+		name: controllerName[R2](),
+		build: func(ctx context.Context, deps Deps, mapper meta.RESTMapper, queue *requestQueue) (reconcile.Reconciler, []watchSource, int, error) {
+			r, err := newReconciler[R2](deps)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			sources, workers, err := captureWatches(ctx, deps, mapper, queue, func(mgr manager.Manager) error {
+				return setup(r, mgr)
+			})
+			return r, sources, workers, err
+		},
+	})
+	return s
+}
+
+// ReconcileWith makes the following steps reconcile the requests of the controller of
+// type R2, either the main one or one registered via WithController, until another
+// controller is selected. The steps are evaluated against the object of the current
+// request, ie the latest one reconciled by any controller.
+func ReconcileWith[R2 reconcile.Reconciler, T client.Object](s _reconcileNextRequest[T]) _reconcileNextRequest[T] {
+	steps := s.(stepsBuilder[T])
+	steps.selectController(controllerName[R2]())
+	return s
+}
+
+// controllerSpec describes an additional controller registered in the scenario.
+type controllerSpec struct {
+	name  string
+	build func(ctx context.Context, deps Deps, mapper meta.RESTMapper, queue *requestQueue) (reconcile.Reconciler, []watchSource, int, error)
+}
+
+// controller is a reconciler running in the scenario, with its own queue and watches.
+type controller struct {
+	name       string
+	reconciler reconcile.Reconciler
+	queue      *requestQueue
+	watchers   []watcher
+	workers    int // requests reconciled in a scheduler turn
+}
+
+// controllerName identifies a controller by its reconciler type.
+func controllerName[R reconcile.Reconciler]() string {
+	return reflect.TypeOf((*R)(nil)).Elem().String()
+}
+
+// captureWatches invokes the controller setup against a fake manager, and starts
+// the controllers added until the context is done, routing their requests to the
+// queue. It returns the watches registered, and the number of workers configured.
+func captureWatches(ctx context.Context, deps Deps, mapper meta.RESTMapper, queue *requestQueue, setup func(mgr manager.Manager) error) ([]watchSource, int, error) {
+	mgr := newFakeManager(deps, mapper)
+	if err := setup(mgr); err != nil {
+		return nil, 0, fmt.Errorf("reconciler setup failure: %w", err)
+	}
+	sources, workers, err := mgr.startControllers(ctx, queue)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(sources) == 0 {
+		return nil, 0, fmt.Errorf("no watches found in the reconciler setup")
+	}
+	return sources, workers, nil
+}
+
+// setupControllers creates the additional controllers, sharing the scenario cluster
+// and clock. The main controller is always the first one, and it's initially selected.
+func (s *scenario[R, T]) setupControllers(ctx context.Context, mapper meta.RESTMapper, workers int) error {
+	s.active = &controller{
+		name:       controllerName[R](),
+		reconciler: s.reconciler,
+		queue:      s.queue,
+		watchers:   s.watchers,
+		workers:    workers,
+	}
+	s.controllers = []*controller{s.active}
+	for _, spec := range s.controllerSpecs {
+		queue := newRequestQueue(s.clock)
+		r, sources, workers, err := spec.build(ctx, s.deps, mapper, queue)
+		if err != nil {
+			return fmt.Errorf("controller %s: %w", spec.name, err)
+		}
+		watchers, err := newWatchers(sources, s.deps.Scheme, mapper)
+		if err != nil {
+			return fmt.Errorf("controller %s: %w", spec.name, err)
+		}
+		s.controllers = append(s.controllers, &controller{
+			name:       spec.name,
+			reconciler: r,
+			queue:      queue,
+			watchers:   watchers,
+			workers:    workers,
+		})
+	}
+	s.global = false
+	s.nextController = 0
+	s.turn = 0
+	return nil
+}
+
+// selectController adds a step selecting the controller for the following steps.
+func (s *scenario[R, T]) selectController(name string) {
+	s.steps = append(s.steps, reconcileStep[T]{
+		controller: name,
+	})
+}
+
+// useController makes the given controller the one reconciled by the steps.
+func (s *scenario[R, T]) useController(name string) error {
+	if name == allControllers {
+		s.global = true
+		s.turn = 0
+		return nil
+	}
+	for _, c := range s.controllers {
+		if c.name == name {
+			s.global = false
+			s.activate(c)
+			return nil
+		}
+	}
+	return fmt.Errorf("controller %s not registered", name)
+}
+
+func (s *scenario[R, T]) activate(c *controller) {
+	s.active = c
+	s.reconciler = c.reconciler
+	s.queue = c.queue
+	s.watchers = c.watchers
+}
+
+// schedule selects, in a round robin fashion, the next controller having at least
+// one request ready to be reconciled. In its turn, a controller keeps being selected
+// for up to its number of workers requests. If none is found, the pending requests
+// are described in the returned error.
+func (s *scenario[R, T]) schedule() error {
+	if s.turn > 0 && s.active.queue.Len() > 0 {
+		s.turn--
+		return nil
+	}
+	for i := range s.controllers {
+		idx := (s.nextController + i) % len(s.controllers)
+		if c := s.controllers[idx]; c.queue.Len() > 0 {
+			s.nextController = (idx + 1) % len(s.controllers)
+			s.activate(c)
+			s.turn = c.workers - 1
+			return nil
+		}
+	}
+
+	queues := make([]*requestQueue, 0, len(s.controllers))
+	for _, c := range s.controllers {
+		queues = append(queues, c.queue)
+	}
+	return pendingRequests(queues...)
+}
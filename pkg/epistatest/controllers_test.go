@@ -0,0 +1,141 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// TestSecretController labels with `checked` every secret.
+type TestSecretController struct {
+	client.Client
+}
+
+func (s TestSecretController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	secret := &corev1.Secret{}
+	if err := s.Get(ctx, req.NamespacedName, secret); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if secret.Labels["checked"] == "true" {
+		return ctrl.Result{}, nil
+	}
+	secret.Labels = map[string]string{"checked": "true"}
+	return ctrl.Result{}, s.Update(ctx, secret)
+}
+
+func (s TestSecretController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Secret{}).
+		Complete(s)
+}
+
+func secretChecked(c client.Client, name string) bool {
+	secret := &corev1.Secret{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "cm"}, secret); err != nil {
+		return false
+	}
+	return secret.Labels["checked"] == "true"
+}
+
+func newTestScenarioWithControllers() Scenario[TestControllerWithWatches, *corev1.ConfigMap] {
+	return WithController(newTestScenarioWithWatches().
+		FromSetupWithManager(TestControllerWithWatches.SetupWithManager),
+		TestSecretController.SetupWithManager)
+}
+
+func TestMultipleControllers(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "global settle",
+			testCase: newTestScenarioWithControllers().
+				Setup(testScenarioBuilder{}).
+				ReconcileAll().
+				ReconcileUntilIdle().
+				VerifyCalls(func(calls []Call) error {
+					for _, c := range calls {
+						if c.IsWrite() && c.GVK.Kind == "Secret" && c.Verb == VerbUpdate && c.Controller != "epistatest.TestSecretController" {
+							return fmt.Errorf("unexpected secret update by %s", c.Controller)
+						}
+					}
+					return nil
+				}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretChecked(client, "cm0") && secretChecked(client, "cm1")
+				}, "secrets checked"),
+		},
+		{
+			name: "workers turn",
+			testCase: WithController(newTestScenarioWithWatches().
+				FromSetupWithManager(func(r TestControllerWithWatches, mgr manager.Manager) error {
+					return ctrl.NewControllerManagedBy(mgr).
+						For(&corev1.ConfigMap{}).
+						Owns(&corev1.Secret{}).
+						WithOptions(ctrlcontroller.Options{MaxConcurrentReconciles: 2}).
+						Complete(r)
+				}),
+				TestSecretController.SetupWithManager).
+				Setup(testScenarioBuilder{}).
+				ReconcileAll().
+				ReconcileUntilIdle().
+				VerifyCalls(func(calls []Call) error {
+					for _, c := range calls {
+						if c.Reconcile <= 2 && c.Controller != "epistatest.TestControllerWithWatches" {
+							return fmt.Errorf("reconcile #%d by %s", c.Reconcile, c.Controller)
+						}
+					}
+					return nil
+				}),
+		},
+		{
+			name: "main controller by default",
+			testCase: ReconcileWith[TestSecretController](newTestScenarioWithControllers().
+				Setup(testScenarioBuilder{}).
+				ReconcileUntilIdle().
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0") && !secretChecked(client, "cm0")
+				}, "secrets not yet checked")).
+				ReconcileUntilIdle().
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretChecked(client, "cm0") && secretChecked(client, "cm1")
+				}, "secrets checked"),
+		},
+		{
+			name: "back to the main controller",
+			testCase: ReconcileWith[TestControllerWithWatches](ReconcileWith[TestSecretController](newTestScenarioWithControllers().
+				Setup(testScenarioBuilder{})).
+				ReconcileUntilIdle("nothing to check yet")).
+				ReconcileUntilIdle().
+				ExpectCalls(VerbCreate, corev1.SchemeGroupVersion.WithKind("Secret"), 2),
+		},
+		{
+			name: "unregistered controller",
+			testCase: ReconcileWith[TestController](newTestScenarioWithControllers().
+				Setup(testScenarioBuilder{})).
+				ReconcileUntilIdle(),
+			expectedError: "step `` failure: controller epistatest.TestController not registered",
+		},
+		{
+			name: "controller setup failure",
+			testCase: WithController(newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}),
+				func(r TestSecretController, mgr manager.Manager) error {
+					return fmt.Errorf("boom")
+				}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntilIdle(),
+			expectedError: "controller epistatest.TestSecretController: reconciler setup failure: boom",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestControllerWithWatches, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
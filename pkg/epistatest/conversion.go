@@ -0,0 +1,357 @@
+package epistatest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// versionConverter converts the objects of the multi-version kinds between the
+// version used by the caller and the storage one.
+type versionConverter struct {
+	scheme  *runtime.Scheme
+	storage map[schema.GroupKind]schema.GroupVersionKind
+}
+
+func newVersionConverter(storageVersions []client.Object, scheme *runtime.Scheme) (*versionConverter, error) {
+	vc := &versionConverter{
+		scheme:  scheme,
+		storage: map[schema.GroupKind]schema.GroupVersionKind{},
+	}
+	for _, obj := range storageVersions {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, fmt.Errorf("storage version %T: %w", obj, err)
+		}
+		if other, found := vc.storage[gvk.GroupKind()]; found && other != gvk {
+			return nil, fmt.Errorf("multiple storage versions for %s: %s, %s", gvk.GroupKind(), other.Version, gvk.Version)
+		}
+		vc.storage[gvk.GroupKind()] = gvk
+	}
+	return vc, nil
+}
+
+// storageVersion returns the storage version of the given kind, if any. The converter
+// could be nil, when no storage version was declared.
+func (vc *versionConverter) storageVersion(gk schema.GroupKind) (schema.GroupVersionKind, bool) {
+	if vc == nil {
+		return schema.GroupVersionKind{}, false
+	}
+	gvk, found := vc.storage[gk]
+	return gvk, found
+}
+
+// storageObject returns a new instance of the storage version of the object kind,
+// or nil if the object is already in the storage version, or not converted at all.
+func (vc *versionConverter) storageObject(obj runtime.Object) client.Object {
+	gvk, err := apiutil.GVKForObject(obj, vc.scheme)
+	if err != nil {
+		return nil
+	}
+	storage, found := vc.storage[gvk.GroupKind()]
+	if !found || storage == gvk {
+		return nil
+	}
+	o, err := vc.scheme.New(storage)
+	if err != nil {
+		return nil
+	}
+	return o.(client.Object)
+}
+
+// convert copies the source object into the destination one, of a different version
+// of the same kind. The Hub and Convertible implementations are used if available,
+// otherwise the conversion functions registered in the scheme. A conversion between
+// two versions both different from the storage one passes through the storage version.
+func (vc *versionConverter) convert(src, dst runtime.Object) error {
+	srcGVK, err := apiutil.GVKForObject(src, vc.scheme)
+	if err != nil {
+		return err
+	}
+	dstGVK, err := apiutil.GVKForObject(dst, vc.scheme)
+	if err != nil {
+		return err
+	}
+	if storage := vc.storageObject(src); storage != nil && vc.storageObject(dst) != nil && srcGVK != dstGVK {
+		if err := vc.convert(src, storage); err != nil {
+			return err
+		}
+		src = storage
+	}
+
+	// The conversion always starts from an empty object.
+	converted := reflect.New(reflect.TypeOf(dst).Elem()).Interface().(runtime.Object)
+	switch {
+	case srcGVK == dstGVK:
+		converted = src.DeepCopyObject()
+	case isHub(src) && isConvertible(converted):
+		err = converted.(conversion.Convertible).ConvertFrom(src.(conversion.Hub))
+	case isConvertible(src) && isHub(converted):
+		err = src.(conversion.Convertible).ConvertTo(converted.(conversion.Hub))
+	default:
+		err = vc.scheme.Convert(src, converted, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("converting %s to %s: %w", srcGVK, dstGVK, err)
+	}
+	if !src.GetObjectKind().GroupVersionKind().Empty() {
+		converted.GetObjectKind().SetGroupVersionKind(dstGVK)
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(converted).Elem())
+	return nil
+}
+
+func isHub(obj runtime.Object) bool {
+	_, ok := obj.(conversion.Hub)
+	return ok
+}
+
+func isConvertible(obj runtime.Object) bool {
+	_, ok := obj.(conversion.Convertible)
+	return ok
+}
+
+// toStorage returns the object converted in its storage version, or the object
+// itself if not converted.
+func (vc *versionConverter) toStorage(obj client.Object) (client.Object, error) {
+	storage := vc.storageObject(obj)
+	if storage == nil {
+		return obj, nil
+	}
+	return storage, vc.convert(obj, storage)
+}
+
+// convertChange converts the objects of the change to the given version of their kind.
+func (vc *versionConverter) convertChange(change objectChange, gvk schema.GroupVersionKind) (objectChange, error) {
+	converted := objectChange{}
+	for _, c := range []struct {
+		src client.Object
+		dst *client.Object
+	}{{change.Old, &converted.Old}, {change.New, &converted.New}} {
+		if c.src == nil {
+			continue
+		}
+		obj, err := vc.scheme.New(gvk)
+		if err != nil {
+			return objectChange{}, err
+		}
+		if err := vc.convert(c.src, obj); err != nil {
+			return objectChange{}, err
+		}
+		*c.dst = obj.(client.Object)
+	}
+	return converted, nil
+}
+
+// withConversion wraps the client so that the objects of the kinds with a registered
+// storage version are always stored in that version, and converted back to the version
+// requested by the caller when read or written.
+func withConversion(c client.WithWatch, vc *versionConverter) client.WithWatch {
+	// write converts the object to the storage version for the write operation,
+	// and then the stored object back to the caller version.
+	write := func(obj client.Object, op func(stored client.Object) error) error {
+		stored := vc.storageObject(obj)
+		if stored == nil {
+			return op(obj)
+		}
+		if err := vc.convert(obj, stored); err != nil {
+			return err
+		}
+		if err := op(stored); err != nil {
+			return err
+		}
+		return vc.convert(stored, obj)
+	}
+
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			stored := vc.storageObject(obj)
+			if stored == nil {
+				return c.Get(ctx, key, obj, opts...)
+			}
+			if err := c.Get(ctx, key, stored, opts...); err != nil {
+				return err
+			}
+			return vc.convert(stored, obj)
+		},
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			return listConverted(ctx, c, vc, list, opts...)
+		},
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			return write(obj, func(stored client.Object) error {
+				return c.Create(ctx, stored, opts...)
+			})
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			return write(obj, func(stored client.Object) error {
+				return c.Update(ctx, stored, opts...)
+			})
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			return write(obj, func(stored client.Object) error {
+				return c.Delete(ctx, stored, opts...)
+			})
+		},
+		DeleteAllOf: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteAllOfOption) error {
+			if stored := vc.storageObject(obj); stored != nil {
+				return c.DeleteAllOf(ctx, stored, opts...)
+			}
+			return c.DeleteAllOf(ctx, obj, opts...)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			stored := vc.storageObject(obj)
+			if stored == nil {
+				return c.Patch(ctx, obj, patch, opts...)
+			}
+			patchOpts := &client.PatchOptions{}
+			patchOpts.ApplyOptions(opts)
+			return patchConverted(ctx, c, vc, obj, stored, patch, len(patchOpts.DryRun) > 0, func() error {
+				return c.Update(ctx, stored)
+			})
+		},
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			return write(obj, func(stored client.Object) error {
+				return c.SubResource(subResourceName).Update(ctx, stored, opts...)
+			})
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			stored := vc.storageObject(obj)
+			if stored == nil {
+				return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+			}
+			patchOpts := &client.SubResourcePatchOptions{}
+			patchOpts.ApplyOptions(opts)
+			return patchConverted(ctx, c, vc, obj, stored, patch, len(patchOpts.DryRun) > 0, func() error {
+				return c.SubResource(subResourceName).Update(ctx, stored)
+			})
+		},
+	})
+}
+
+// listConverted lists the objects in their storage version, and converts them to the
+// version of the list items.
+func listConverted(ctx context.Context, c client.Client, vc *versionConverter, list client.ObjectList, opts ...client.ListOption) error {
+	listGVK, err := apiutil.GVKForObject(list, vc.scheme)
+	if err != nil || !strings.HasSuffix(listGVK.Kind, "List") {
+		return c.List(ctx, list, opts...)
+	}
+	itemGVK := listGVK.GroupVersion().WithKind(strings.TrimSuffix(listGVK.Kind, "List"))
+	item, err := vc.scheme.New(itemGVK)
+	if err != nil {
+		return c.List(ctx, list, opts...)
+	}
+	stored := vc.storageObject(item)
+	if stored == nil {
+		return c.List(ctx, list, opts...)
+	}
+	storedGVK, err := apiutil.GVKForObject(stored, vc.scheme)
+	if err != nil {
+		return err
+	}
+	o, err := vc.scheme.New(storedGVK.GroupVersion().WithKind(storedGVK.Kind + "List"))
+	if err != nil {
+		return err
+	}
+	storedList := o.(client.ObjectList)
+	if err := c.List(ctx, storedList, opts...); err != nil {
+		return err
+	}
+
+	storedItems, err := meta.ExtractList(storedList)
+	if err != nil {
+		return err
+	}
+	items := make([]runtime.Object, 0, len(storedItems))
+	for _, s := range storedItems {
+		item, err := vc.scheme.New(itemGVK)
+		if err != nil {
+			return err
+		}
+		if err := vc.convert(s, item); err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	if err := meta.SetList(list, items); err != nil {
+		return err
+	}
+	list.SetResourceVersion(storedList.GetResourceVersion())
+	list.SetContinue(storedList.GetContinue())
+	return nil
+}
+
+// patchConverted applies the patch to the stored object converted in the caller version,
+// since the patch was computed on it, and then it stores the result via update (unless
+// it is a dry run). Server-side apply is not supported.
+func patchConverted(ctx context.Context, c client.Client, vc *versionConverter, obj client.Object, stored client.Object, patch client.Patch, dryRun bool, update func() error) error {
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), stored); err != nil {
+		return err
+	}
+	current := obj.DeepCopyObject().(client.Object)
+	if err := vc.convert(stored, current); err != nil {
+		return err
+	}
+	patched, err := applyPatch(current, obj, patch)
+	if err != nil {
+		return err
+	}
+	if err := vc.convert(patched, stored); err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := update(); err != nil {
+			return err
+		}
+	}
+	return vc.convert(stored, obj)
+}
+
+// applyPatch returns the result of applying to the current object the patch computed
+// on obj, without storing it. Server-side apply is not supported.
+func applyPatch(current client.Object, obj client.Object, patch client.Patch) (client.Object, error) {
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var patchedJSON []byte
+	switch patch.Type() {
+	case types.MergePatchType:
+		patchedJSON, err = jsonpatch.MergePatch(currentJSON, data)
+	case types.JSONPatchType:
+		var p jsonpatch.Patch
+		if p, err = jsonpatch.DecodePatch(data); err == nil {
+			patchedJSON, err = p.Apply(currentJSON)
+		}
+	case types.StrategicMergePatchType:
+		patchedJSON, err = strategicpatch.StrategicMergePatch(currentJSON, data, current)
+	default:
+		err = fmt.Errorf("patch type %s not supported", patch.Type())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	patched := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(client.Object)
+	if err := json.Unmarshal(patchedJSON, patched); err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
@@ -0,0 +1,256 @@
+package epistatest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlconversion "sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+var (
+	widgetV1alpha1GV = schema.GroupVersion{Group: "test.epistatest.io", Version: "v1alpha1"}
+	widgetV1beta1GV  = schema.GroupVersion{Group: "test.epistatest.io", Version: "v1beta1"}
+)
+
+// WidgetV1alpha1 is an old version of Widget, converted via the Convertible interface.
+type WidgetV1alpha1 struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WidgetV1alpha1Spec `json:"spec,omitempty"`
+	Status WidgetStatus       `json:"status,omitempty"`
+}
+
+type WidgetV1alpha1Spec struct {
+	Replicas int    `json:"replicas"`
+	Colour   string `json:"colour,omitempty"`
+}
+
+func (w *WidgetV1alpha1) DeepCopyObject() runtime.Object {
+	out := *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+
+func (w *WidgetV1alpha1) ConvertTo(dst ctrlconversion.Hub) error {
+	hub := dst.(*Widget)
+	hub.ObjectMeta = *w.ObjectMeta.DeepCopy()
+	hub.Spec = WidgetSpec{Size: w.Spec.Replicas, Color: w.Spec.Colour}
+	hub.Status = w.Status
+	return nil
+}
+
+func (w *WidgetV1alpha1) ConvertFrom(src ctrlconversion.Hub) error {
+	hub := src.(*Widget)
+	w.ObjectMeta = *hub.ObjectMeta.DeepCopy()
+	w.Spec = WidgetV1alpha1Spec{Replicas: hub.Spec.Size, Colour: hub.Spec.Color}
+	w.Status = hub.Status
+	return nil
+}
+
+type WidgetV1alpha1List struct {
+	v1.TypeMeta `json:",inline"`
+	v1.ListMeta `json:"metadata,omitempty"`
+	Items       []WidgetV1alpha1 `json:"items"`
+}
+
+func (wl *WidgetV1alpha1List) DeepCopyObject() runtime.Object {
+	out := *wl
+	out.Items = make([]WidgetV1alpha1, len(wl.Items))
+	for i := range wl.Items {
+		out.Items[i] = *wl.Items[i].DeepCopyObject().(*WidgetV1alpha1)
+	}
+	return &out
+}
+
+// WidgetV1beta1 is another version of Widget, converted via the scheme conversion functions.
+type WidgetV1beta1 struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WidgetSpec `json:"spec,omitempty"`
+}
+
+func (w *WidgetV1beta1) DeepCopyObject() runtime.Object {
+	out := *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+
+type WidgetV1beta1List struct {
+	v1.TypeMeta `json:",inline"`
+	v1.ListMeta `json:"metadata,omitempty"`
+	Items       []WidgetV1beta1 `json:"items"`
+}
+
+func (wl *WidgetV1beta1List) DeepCopyObject() runtime.Object {
+	out := *wl
+	out.Items = make([]WidgetV1beta1, len(wl.Items))
+	for i := range wl.Items {
+		out.Items[i] = *wl.Items[i].DeepCopyObject().(*WidgetV1beta1)
+	}
+	return &out
+}
+
+func addWidgetVersionsToScheme(s *runtime.Scheme) error {
+	s.AddKnownTypeWithName(widgetV1alpha1GV.WithKind("Widget"), &WidgetV1alpha1{})
+	s.AddKnownTypeWithName(widgetV1alpha1GV.WithKind("WidgetList"), &WidgetV1alpha1List{})
+	v1.AddToGroupVersion(s, widgetV1alpha1GV)
+	s.AddKnownTypeWithName(widgetV1beta1GV.WithKind("Widget"), &WidgetV1beta1{})
+	s.AddKnownTypeWithName(widgetV1beta1GV.WithKind("WidgetList"), &WidgetV1beta1List{})
+	v1.AddToGroupVersion(s, widgetV1beta1GV)
+
+	if err := s.AddConversionFunc((*WidgetV1beta1)(nil), (*Widget)(nil), func(a, b any, scope conversion.Scope) error {
+		src, dst := a.(*WidgetV1beta1), b.(*Widget)
+		dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+		dst.Spec = src.Spec
+		return nil
+	}); err != nil {
+		return err
+	}
+	return s.AddConversionFunc((*Widget)(nil), (*WidgetV1beta1)(nil), func(a, b any, scope conversion.Scope) error {
+		src, dst := a.(*Widget), b.(*WidgetV1beta1)
+		dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+		dst.Spec = src.Spec
+		return nil
+	})
+}
+
+func oldWidget() []client.Object {
+	return []client.Object{&WidgetV1alpha1{
+		ObjectMeta: v1.ObjectMeta{Name: "w0", Namespace: "ns"},
+		Spec:       WidgetV1alpha1Spec{Replicas: 2, Colour: "blue"},
+	}}
+}
+
+func getOldWidget(c client.Client) *WidgetV1alpha1 {
+	w := &WidgetV1alpha1{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "w0", Namespace: "ns"}, w); err != nil {
+		return nil
+	}
+	return w
+}
+
+func newConversionScenario(objs func() []client.Object) _reconcileNextRequest[*Widget] {
+	return New[WidgetController, *Widget]().
+		WithSchemes(addWidgetsToScheme, addWidgetVersionsToScheme).
+		WithStorageVersion(&Widget{}).
+		SetupObjects(objs)
+}
+
+func TestConversion(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "setup in an old version",
+			testCase: newConversionScenario(oldWidget).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Size == 2 && obj.Spec.Color == "blue" && obj.Status.Phase == "Ready"
+				}),
+		},
+		{
+			name: "old version read",
+			testCase: newConversionScenario(oldWidget).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(c client.Client, obj *Widget) bool {
+					list := &WidgetV1alpha1List{}
+					if err := c.List(context.Background(), list); err != nil || len(list.Items) != 1 {
+						return false
+					}
+					old := getOldWidget(c)
+					return old != nil && old.Status.Phase == "Ready" && list.Items[0].Spec.Replicas == 2
+				}),
+		},
+		{
+			name: "old version written",
+			testCase: newConversionScenario(oldWidget).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(func(c client.Client, obj *Widget) {
+					old := getOldWidget(c)
+					old.Spec.Replicas = 3
+					_ = c.Update(context.Background(), old)
+				}).
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Size == 3 && obj.Generation == 2
+				}).
+				Then(func(c client.Client, obj *Widget) {
+					old := getOldWidget(c)
+					original := old.DeepCopyObject().(*WidgetV1alpha1)
+					old.Spec.Colour = "red"
+					_ = c.Patch(context.Background(), old, client.MergeFrom(original))
+				}).
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Size == 3 && obj.Spec.Color == "red" && obj.Generation == 3
+				}),
+		},
+		{
+			name: "scheme conversion functions",
+			testCase: newConversionScenario(func() []client.Object {
+				return []client.Object{&WidgetV1beta1{
+					ObjectMeta: v1.ObjectMeta{Name: "w0", Namespace: "ns"},
+					Spec:       WidgetSpec{Size: 4},
+				}}
+			}).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Size == 4 && obj.Status.Phase == "Ready"
+				}),
+		},
+		{
+			name: "watches on the new version",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme, addWidgetVersionsToScheme).
+				WithStorageVersion(&Widget{}).
+				For(&Widget{}).
+				SetupObjects(oldWidget).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Status.Phase == "Ready"
+				}),
+		},
+		{
+			name: "no storage version",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme, addWidgetVersionsToScheme).
+				SetupObjects(oldWidget).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle(),
+			expectedError: "step `` failure: request ns/w0 refers to a Widget test.epistatest.io/v1alpha1, but test.epistatest.io/v1 was expected (see WithStorageVersion)",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[WidgetController, *Widget](t, tc)
+		})
+	}
+}
+
+func TestStoredKinds(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := addWidgetsToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := addWidgetVersionsToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if kinds := storedKinds(scheme, nil); len(kinds) != 3 {
+		t.Fatalf("expected all the widget versions, but received %v", kinds)
+	}
+
+	vc, err := newVersionConverter([]client.Object{&Widget{}}, scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []schema.GroupVersionKind{widgetGV.WithKind("Widget")}
+	if kinds := storedKinds(scheme, vc); !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("expected only the storage version %v, but received %v", expected, kinds)
+	}
+}
@@ -0,0 +1,288 @@
+package epistatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/cel"
+	structuraldefaulting "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	structuralpruning "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/pruning"
+	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// crdSchema validates and defaults the objects of a custom resource version.
+type crdSchema struct {
+	structural *structuralschema.Structural
+	validator  apiservervalidation.SchemaValidator
+	rules      *cel.Validator // x-kubernetes-validations rules, if any
+	status     bool           // status subresource enabled
+}
+
+// crdSchemas contains the schemas of all the loaded custom resource versions.
+type crdSchemas map[schema.GroupVersionKind]*crdSchema
+
+// loadCRDs reads all the CustomResourceDefinition manifests found in the given files,
+// or in the yaml and json files of the given directories. Other kinds are ignored.
+func loadCRDs(paths []string) ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".yaml", ".yml", ".json":
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+
+	var crds []*apiextensionsv1.CustomResourceDefinition
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		decoder := yaml.NewYAMLOrJSONDecoder(f, 4096)
+		for {
+			crd := &apiextensionsv1.CustomResourceDefinition{}
+			err := decoder.Decode(crd)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			if crd.Kind == "CustomResourceDefinition" {
+				crds = append(crds, crd)
+			}
+		}
+		f.Close()
+	}
+	return crds, nil
+}
+
+// newCRDSchemas builds the schemas of all the served versions of the custom resources.
+func newCRDSchemas(crds []*apiextensionsv1.CustomResourceDefinition) (crdSchemas, error) {
+	schemas := crdSchemas{}
+	for _, crd := range crds {
+		for _, v := range crd.Spec.Versions {
+			if !v.Served || v.Schema == nil || v.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+			internal := &apiextensions.CustomResourceValidation{}
+			if err := apiextensionsv1.Convert_v1_CustomResourceValidation_To_apiextensions_CustomResourceValidation(v.Schema, internal, nil); err != nil {
+				return nil, fmt.Errorf("crd %s version %s: %w", crd.Name, v.Name, err)
+			}
+			structural, err := structuralschema.NewStructural(internal.OpenAPIV3Schema)
+			if err != nil {
+				return nil, fmt.Errorf("crd %s version %s: %w", crd.Name, v.Name, err)
+			}
+			validator, _, err := apiservervalidation.NewSchemaValidator(internal.OpenAPIV3Schema)
+			if err != nil {
+				return nil, fmt.Errorf("crd %s version %s: %w", crd.Name, v.Name, err)
+			}
+			gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: v.Name, Kind: crd.Spec.Names.Kind}
+			schemas[gvk] = &crdSchema{
+				structural: structural,
+				validator:  validator,
+				rules:      cel.NewValidator(structural, true, celconfig.PerCallLimit),
+				status:     v.Subresources != nil && v.Subresources.Status != nil,
+			}
+		}
+	}
+	return schemas, nil
+}
+
+// statusSubresources returns a new instance of every custom resource kind with the status
+// subresource enabled, and registered in the scheme.
+func (cs crdSchemas) statusSubresources(scheme *runtime.Scheme) []client.Object {
+	var objs []client.Object
+	for gvk, s := range cs {
+		if !s.status {
+			continue
+		}
+		if obj, err := scheme.New(gvk); err == nil {
+			if o, ok := obj.(client.Object); ok {
+				objs = append(objs, o)
+			}
+		}
+	}
+	return objs
+}
+
+// prepareUpdate resets the fields of an object of the given kind that an update cannot
+// change when the status subresource is enabled, like the API server does before the
+// admission: an update of the main resource keeps the stored status, while an update of
+// the status keeps everything else but the resource version. The old object is nil for
+// a creation, which is left untouched like the objects of kinds not defined by the CRDs.
+func (cs crdSchemas) prepareUpdate(gvk schema.GroupVersionKind, obj client.Object, old client.Object, subResource bool) error {
+	s, found := cs[gvk]
+	if !found || !s.status || old == nil {
+		return nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	oldContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+	if err != nil {
+		return err
+	}
+
+	source, target := oldContent, content
+	if subResource {
+		source, target = content, oldContent
+	}
+	if status, found := source["status"]; found {
+		target["status"] = status
+	} else {
+		delete(target, "status")
+	}
+
+	resourceVersion := obj.GetResourceVersion()
+	if err := setContent(obj, target); err != nil {
+		return err
+	}
+	obj.SetResourceVersion(resourceVersion)
+	return nil
+}
+
+// applyDefaults applies the schema defaults to an object of the given kind, like the
+// API server does before invoking the mutating webhooks. Objects of kinds not defined
+// by the loaded CRDs are left untouched.
+func (cs crdSchemas) applyDefaults(gvk schema.GroupVersionKind, obj client.Object) error {
+	s, found := cs[gvk]
+	if !found {
+		return nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	structuraldefaulting.Default(content, s.structural)
+	return setContent(obj, content)
+}
+
+// prune drops the fields of an object of the given kind not specified by its schema,
+// unless preserved by x-kubernetes-preserve-unknown-fields, like the API server does
+// before validating it. Objects of kinds not defined by the loaded CRDs are left untouched.
+func (cs crdSchemas) prune(gvk schema.GroupVersionKind, obj client.Object) error {
+	s, found := cs[gvk]
+	if !found {
+		return nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	structuralpruning.Prune(content, s.structural, true)
+	return setContent(obj, content)
+}
+
+// setContent replaces the content of the object with the unstructured one.
+func setContent(obj client.Object, content map[string]any) error {
+	if u, ok := obj.(runtime.Unstructured); ok {
+		u.SetUnstructuredContent(content)
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(content, obj)
+}
+
+// validate validates an object of the given kind against its schema, including the
+// x-kubernetes-validations rules, like the API server, returning an Invalid error in
+// case of failure. The old object is nil for a creation, so that the transition rules
+// are skipped. Objects of kinds not defined by the loaded CRDs are always accepted.
+func (cs crdSchemas) validate(ctx context.Context, gvk schema.GroupVersionKind, obj client.Object, old client.Object) error {
+	s, found := cs[gvk]
+	if !found {
+		return nil
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+
+	var errs field.ErrorList
+	var oldObj any
+	if old == nil {
+		errs = apiservervalidation.ValidateCustomResource(nil, content, s.validator)
+	} else {
+		oldContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+		if err != nil {
+			return err
+		}
+		errs = apiservervalidation.ValidateCustomResourceUpdate(nil, content, oldContent, s.validator)
+		oldObj = oldContent
+	}
+	if s.rules != nil {
+		ruleErrs, _ := s.rules.Validate(ctx, nil, s.structural, content, oldObj, celconfig.RuntimeCELCostBudget)
+		errs = append(errs, ruleErrs...)
+	}
+	if len(errs) > 0 {
+		return k8serr.NewInvalid(gvk.GroupKind(), obj.GetName(), errs)
+	}
+	return nil
+}
+
+// rejectedWrites records the first write rejected by the admission.
+type rejectedWrites struct {
+	err error
+}
+
+// withRejectedWrites wraps the client to record the writes rejected by the admission,
+// for example as invalid by the schema validation, or as forbidden by a webhook.
+func withRejectedWrites(c client.WithWatch, rejected *rejectedWrites) client.WithWatch {
+	record := func(err error) error {
+		if (k8serr.IsInvalid(err) || k8serr.IsForbidden(err)) && rejected.err == nil {
+			rejected.err = err
+		}
+		return err
+	}
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			return record(c.Create(ctx, obj, opts...))
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			return record(c.Update(ctx, obj, opts...))
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			return record(c.Patch(ctx, obj, patch, opts...))
+		},
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			return record(c.SubResource(subResourceName).Update(ctx, obj, opts...))
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			return record(c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...))
+		},
+	})
+}
@@ -0,0 +1,327 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var widgetGV = schema.GroupVersion{Group: "test.epistatest.io", Version: "v1"}
+
+// Widget is a custom resource defined by testdata/widgets.yaml.
+type Widget struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WidgetSpec   `json:"spec,omitempty"`
+	Status WidgetStatus `json:"status,omitempty"`
+}
+
+type WidgetSpec struct {
+	Size  int    `json:"size"`
+	Color string `json:"color,omitempty"`
+	// Shape is not defined by the CRD schema, so it's always pruned.
+	Shape string `json:"shape,omitempty"`
+}
+
+type WidgetStatus struct {
+	Phase string `json:"phase,omitempty"`
+}
+
+// Hub marks Widget as the conversion hub of the widget versions.
+func (*Widget) Hub() {}
+
+func (w *Widget) DeepCopyObject() runtime.Object {
+	out := *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+
+type WidgetList struct {
+	v1.TypeMeta `json:",inline"`
+	v1.ListMeta `json:"metadata,omitempty"`
+	Items       []Widget `json:"items"`
+}
+
+func (wl *WidgetList) DeepCopyObject() runtime.Object {
+	out := *wl
+	out.Items = make([]Widget, len(wl.Items))
+	for i := range wl.Items {
+		out.Items[i] = *wl.Items[i].DeepCopyObject().(*Widget)
+	}
+	return &out
+}
+
+func addWidgetsToScheme(s *runtime.Scheme) error {
+	s.AddKnownTypes(widgetGV, &Widget{}, &WidgetList{})
+	v1.AddToGroupVersion(s, widgetGV)
+	return nil
+}
+
+// WidgetController sets the widget phase, taken from the phase annotation if present.
+type WidgetController struct {
+	client.Client
+}
+
+func (c WidgetController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	w := &Widget{}
+	if err := c.Get(ctx, req.NamespacedName, w); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if w.Status.Phase != "" {
+		return ctrl.Result{}, nil
+	}
+	w.Status.Phase = "Ready"
+	if phase, found := w.Annotations["phase"]; found {
+		w.Status.Phase = phase
+	}
+	return ctrl.Result{}, c.Status().Update(ctx, w)
+}
+
+func widget(size int, annotations ...string) func() []client.Object {
+	return func() []client.Object {
+		w := &Widget{
+			ObjectMeta: v1.ObjectMeta{Name: "w0", Namespace: "ns", Annotations: map[string]string{}},
+			Spec:       WidgetSpec{Size: size},
+		}
+		for i := 0; i+1 < len(annotations); i += 2 {
+			w.Annotations[annotations[i]] = annotations[i+1]
+		}
+		return []client.Object{w}
+	}
+}
+
+func newWidgetScenario(objs func() []client.Object) _reconcileNextRequest[*Widget] {
+	return New[WidgetController, *Widget]().
+		WithSchemes(addWidgetsToScheme).
+		WithCRDs("testdata").
+		SetupObjects(objs)
+}
+
+func TestCRDs(t *testing.T) {
+	mergePatch, lockedPatch, dryRunPatch := &widgetPatch{}, &widgetPatch{}, &widgetPatch{}
+	cases := []testCase{
+		{
+			name: "defaulting",
+			testCase: newWidgetScenario(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Color == "red" && obj.Status.Phase == "Ready"
+				}),
+		},
+		{
+			name: "defaulting on patch",
+			testCase: newWidgetScenario(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Status.Phase == "Ready"
+				}).
+				Then(mergePatch.clearColor(client.MergeFrom)).
+				ReconcileUntilE(mergePatch.stored(func(obj *Widget) bool {
+					return obj.Spec.Color == "red" && obj.Generation == 1
+				})),
+		},
+		{
+			name: "defaulting on patch with optimistic lock",
+			testCase: newWidgetScenario(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Status.Phase == "Ready"
+				}).
+				Then(lockedPatch.clearColor(func(obj client.Object) client.Patch {
+					return client.MergeFromWithOptions(obj, client.MergeFromWithOptimisticLock{})
+				})).
+				ReconcileUntilE(lockedPatch.stored(func(obj *Widget) bool {
+					return obj.Spec.Color == "red" && obj.Generation == 1
+				})),
+		},
+		{
+			name: "defaulting on dry-run patch",
+			testCase: newWidgetScenario(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Status.Phase == "Ready"
+				}).
+				Then(dryRunPatch.clearColor(client.MergeFrom, client.DryRunAll)).
+				ReconcileUntilE(dryRunPatch.stored(func(obj *Widget) bool {
+					return obj.Spec.Color == "red" && obj.ResourceVersion == dryRunPatch.resourceVersion
+				})),
+		},
+		{
+			name:          "invalid setup",
+			testCase:      newWidgetScenario(widget(0)).NextRequest("w0", "ns").ReconcileUntilIdle(),
+			expectedError: "Widget.test.epistatest.io \"w0\" is invalid: spec.size: Invalid value: 0: spec.size in body should be greater than or equal to 1",
+		},
+		{
+			name: "invalid update",
+			testCase: newWidgetScenario(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(func(c client.Client, obj *Widget) {
+					obj.Spec.Color = "green"
+					_ = c.Update(context.Background(), obj)
+				}, "paint").
+				ReconcileUntilIdle(),
+			expectedError: "`paint` failure, Widget.test.epistatest.io \"w0\" is invalid: spec.color: Unsupported value: \"green\": supported values: \"red\", \"blue\"",
+		},
+		{
+			name: "invalid status written by the reconciler",
+			testCase: newWidgetScenario(widget(1, "phase", "Broken")).
+				NextRequest("w0", "ns").
+				ExpectError(k8serr.IsInvalid),
+		},
+		{
+			name: "status ignored by the main resource update",
+			testCase: newWidgetScenario(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(func(c client.Client, obj *Widget) {
+					obj.Spec.Size = 2
+					obj.Status.Phase = "Broken"
+					_ = c.Update(context.Background(), obj)
+				}, "resize").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Size == 2 && obj.Status.Phase == "Ready"
+				}),
+		},
+		{
+			name: "spec ignored by the status update",
+			testCase: newWidgetScenario(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(func(c client.Client, obj *Widget) {
+					obj.Spec.Color = "green"
+					obj.Status.Phase = "Pending"
+					_ = c.Status().Update(context.Background(), obj)
+				}, "pending").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Color == "red" && obj.Status.Phase == "Pending"
+				}),
+		},
+		{
+			name: "unknown fields pruned",
+			testCase: newWidgetScenario(func() []client.Object {
+				w := widget(1)()[0].(*Widget)
+				w.Spec.Shape = "round"
+				return []client.Object{w}
+			}).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Status.Phase == "Ready" && obj.Spec.Shape == ""
+				}),
+		},
+		{
+			name: "missing CRDs",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithCRDs("testdata/missing").
+				SetupObjects(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle(),
+			expectedError: "stat testdata/missing: no such file or directory",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[WidgetController, *Widget](t, tc)
+		})
+	}
+}
+
+// widgetPatch clears the widget color with a patch, to verify that the color is
+// defaulted again by the admission.
+type widgetPatch struct {
+	err             error
+	resourceVersion string
+	patched         *Widget
+}
+
+func (p *widgetPatch) clearColor(newPatch func(obj client.Object) client.Patch, opts ...client.PatchOption) func(client.Client, *Widget) {
+	return func(c client.Client, obj *Widget) {
+		p.resourceVersion = obj.ResourceVersion
+		patch := newPatch(obj.DeepCopyObject().(*Widget))
+		obj.Spec.Color = ""
+		p.err = c.Patch(context.Background(), obj, patch, opts...)
+		p.patched = obj
+	}
+}
+
+// stored verifies that the patch succeeded, returning the patched object defaulted, and
+// that the stored object matches.
+func (p *widgetPatch) stored(match func(obj *Widget) bool) func(client.Client, *Widget) error {
+	return func(_ client.Client, obj *Widget) error {
+		if p.err != nil {
+			return p.err
+		}
+		if p.patched.Spec.Color != "red" {
+			return fmt.Errorf("unexpected patched spec %+v", p.patched.Spec)
+		}
+		if !match(obj) {
+			return fmt.Errorf("unexpected stored widget %+v (generation %d, resource version %s)", obj.Spec, obj.Generation, obj.ResourceVersion)
+		}
+		return nil
+	}
+}
+
+func resize(size int) func(client.Client, *Widget) {
+	return func(c client.Client, obj *Widget) {
+		obj.Spec.Size = size
+		_ = c.Update(context.Background(), obj)
+	}
+}
+
+func TestCRDValidationRules(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "transition allowed",
+			testCase: newWidgetScenario(widget(2)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(resize(3)).
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Size == 3
+				}),
+		},
+		{
+			name: "transition rejected",
+			testCase: newWidgetScenario(widget(2)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(resize(1), "shrink").
+				ReconcileUntilIdle(),
+			expectedError: "`shrink` failure, Widget.test.epistatest.io \"w0\" is invalid: spec: Invalid value: \"object\": size cannot be decreased",
+		},
+		{
+			name: "invalid status written by the reconciler",
+			testCase: newWidgetScenario(widget(11)).
+				NextRequest("w0", "ns").
+				ExpectError(func(err error) bool {
+					return k8serr.IsInvalid(err) && strings.Contains(err.Error(), "widgets larger than 10 cannot be Ready")
+				}),
+		},
+		{
+			name: "invalid setup",
+			testCase: newWidgetScenario(func() []client.Object {
+				w := widget(11)()[0].(*Widget)
+				w.Status.Phase = "Ready"
+				return []client.Object{w}
+			}).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle(),
+			expectedError: "Widget.test.epistatest.io \"w0\" is invalid: <nil>: Invalid value: \"object\": widgets larger than 10 cannot be Ready",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[WidgetController, *Widget](t, tc)
+		})
+	}
+}
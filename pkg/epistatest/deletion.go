@@ -0,0 +1,125 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func (s *scenario[R, T]) DeleteObject(name string, namespace ...string) _reconcileNextRequest[T] {
+	s.deleteObjectFor(func() client.Object { return s.newObjectInstance() }, name, namespace...)
+	return s
+}
+
+// deleteObjectFor adds a step deleting the object of the same type of the one returned
+// by newObj, which then becomes the current request.
+func (s *scenario[R, T]) deleteObjectFor(newObj func() client.Object, name string, namespace ...string) {
+	nextReq := func() (types.NamespacedName, error) {
+		obj := newObj()
+		obj.SetName(name)
+		if len(namespace) > 0 {
+			obj.SetNamespace(namespace[0])
+		}
+		if err := s.client.Delete(context.Background(), obj); err != nil {
+			return types.NamespacedName{}, err
+		}
+		return client.ObjectKeyFromObject(obj), nil
+	}
+
+	s.steps = append(s.steps, reconcileStep[T]{
+		nextReq: nextReq,
+	})
+}
+
+func (s *scenario[R, T]) ExpectFinalizerAdded(finalizer string, labels ...string) _reconcileAction[T] {
+	return s.ReconcileUntilMatch(HasFinalizer(finalizer), labels...)
+}
+
+func (s *scenario[R, T]) ExpectDeleted(labels ...string) _reconcileAction[T] {
+	return s.ReconcileUntilE(func(_ client.Client, obj T) error {
+		return deleted(obj)
+	}, labels...)
+}
+
+// deleted returns an error describing why the object, as fetched for the current
+// request, is still present.
+func deleted(obj client.Object) error {
+	if obj.GetName() == "" {
+		return nil
+	}
+	if obj.GetDeletionTimestamp() == nil {
+		return fmt.Errorf("object %s not being deleted", client.ObjectKeyFromObject(obj))
+	}
+	return fmt.Errorf("object %s deletion blocked by finalizers %s", client.ObjectKeyFromObject(obj), strings.Join(obj.GetFinalizers(), ", "))
+}
+
+// withDeletionLifecycle wraps the client to complete the deletion lifecycle already
+// emulated by the fake client, as enforced by the API server: an object being deleted
+// keeps its original deletion timestamp, and no new finalizers can be added to it.
+// The object is removed as soon as its last finalizer is cleared.
+func withDeletionLifecycle(c client.WithWatch) client.WithWatch {
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if stored := snapshot(ctx, c, obj); stored != nil {
+				if err := verifyFinalizers(c.Scheme(), stored, obj); err != nil {
+					return err
+				}
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			// The patch is applied locally on the object being deleted, to verify the
+			// resulting finalizers.
+			if stored := snapshot(ctx, c, obj); stored != nil && stored.GetDeletionTimestamp() != nil {
+				patched, err := applyPatch(stored, obj, patch)
+				if err != nil {
+					return err
+				}
+				if err := verifyFinalizers(c.Scheme(), stored, patched); err != nil {
+					return err
+				}
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if stored := snapshot(ctx, c, obj); stored != nil && stored.GetDeletionTimestamp() != nil {
+				return nil
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	})
+}
+
+// verifyFinalizers returns an Invalid error if the stored object is being deleted and
+// obj has any finalizer not already present.
+func verifyFinalizers(scheme *runtime.Scheme, stored client.Object, obj client.Object) error {
+	if stored.GetDeletionTimestamp() == nil {
+		return nil
+	}
+	var added []string
+	for _, f := range obj.GetFinalizers() {
+		if !slices.Contains(stored.GetFinalizers(), f) {
+			added = append(added, f)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return err
+	}
+	return k8serr.NewInvalid(gvk.GroupKind(), obj.GetName(), field.ErrorList{
+		field.Forbidden(field.NewPath("metadata", "finalizers"), fmt.Sprintf("no new finalizers can be added if the object is being deleted, found new finalizers %q", added)),
+	})
+}
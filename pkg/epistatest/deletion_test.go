@@ -0,0 +1,187 @@
+package epistatest
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const testFinalizer = "epistatest/cleanup"
+
+// TestFinalizerController creates a secret for every configmap, and it removes
+// the secret before the configmap deletion. Configmaps labeled with `stuck` are
+// never released.
+type TestFinalizerController struct {
+	client.Client
+}
+
+func (s TestFinalizerController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cm := &corev1.ConfigMap{}
+	if err := s.Get(ctx, req.NamespacedName, cm); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: cm.Name, Namespace: cm.Namespace}}
+
+	if cm.DeletionTimestamp != nil {
+		if cm.Labels["stuck"] == "true" {
+			return ctrl.Result{}, nil
+		}
+		if err := s.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(cm, testFinalizer)
+		return ctrl.Result{}, s.Update(ctx, cm)
+	}
+
+	if controllerutil.AddFinalizer(cm, testFinalizer) {
+		if err := s.Update(ctx, cm); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if err := s.Create(ctx, secret); !k8serr.IsAlreadyExists(err) {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+func newTestScenarioWithFinalizers() Scenario[TestFinalizerController, *corev1.ConfigMap] {
+	return New[TestFinalizerController, *corev1.ConfigMap]().
+		WithSchemes(corev1.AddToScheme)
+}
+
+func TestDeleteObject(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "finalizer released",
+			testCase: newTestScenarioWithFinalizers().
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectFinalizerAdded(testFinalizer).
+				DeleteObject("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return !secretExists(client, "cm0")
+				}, "secret removed").
+				ExpectDeleted(),
+		},
+		{
+			name: "finalizer released with watches",
+			testCase: newTestScenarioWithFinalizers().
+				For(&corev1.ConfigMap{}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntilIdle().
+				DeleteObject("cm1", "cm").
+				ExpectDeleted().
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0") && !secretExists(client, "cm1")
+				}),
+		},
+		{
+			name: "object without finalizers",
+			testCase: newTestScenarioWithFinalizers().
+				Setup(testScenarioBuilder{}).
+				DeleteObject("cm0", "cm").
+				ExpectDeleted(),
+		},
+		{
+			name: "finalizer not released",
+			testCase: newTestScenarioWithFinalizers().
+				SetupObjects(func() []client.Object {
+					return []client.Object{&corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "cm0", Namespace: "cm", Labels: map[string]string{"stuck": "true"}}}}
+				}).
+				NextRequest("cm0", "cm").
+				ExpectFinalizerAdded(testFinalizer).
+				DeleteObject("cm0", "cm").
+				ExpectDeleted("deleted"),
+			expectedError: "`deleted` not satisfied (object cm/cm0 deletion blocked by finalizers epistatest/cleanup), too many reconcile loops (20)",
+		},
+		{
+			name: "deletion not requested",
+			testCase: newTestScenarioWithFinalizers().
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectDeleted("deleted"),
+			expectedError: "`deleted` not satisfied (object cm/cm0 not being deleted), too many reconcile loops (20)",
+		},
+		{
+			name: "object not found",
+			testCase: newTestScenarioWithFinalizers().
+				Setup(testScenarioBuilder{}).
+				DeleteObject("missing", "cm").
+				ExpectDeleted(),
+			expectedError: "step `` failure: configmaps \"missing\" not found",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestFinalizerController, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
+
+func TestDeletionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	c := withDeletionLifecycle(fake.NewClientBuilder().
+		WithObjects(&corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "cm0", Namespace: "cm", Finalizers: []string{"a"}}}).
+		Build())
+	key := client.ObjectKey{Name: "cm0", Namespace: "cm"}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, key, cm); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, cm); err != nil {
+		t.Fatal(err)
+	}
+	deletionTimestamp := cm.DeletionTimestamp
+
+	// A second deletion does not change the object.
+	if err := c.Delete(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, cm); err != nil {
+		t.Fatal(err)
+	}
+	if !cm.DeletionTimestamp.Equal(deletionTimestamp) {
+		t.Fatalf("expected deletion timestamp %v, found %v", deletionTimestamp, cm.DeletionTimestamp)
+	}
+
+	// No new finalizers can be added.
+	updated := cm.DeepCopy()
+	updated.Finalizers = append(updated.Finalizers, "b")
+	if err := c.Update(ctx, updated); !k8serr.IsInvalid(err) {
+		t.Fatalf("expected an invalid error on update, received %v", err)
+	}
+	patched := cm.DeepCopy()
+	patched.Finalizers = []string{"c"}
+	if err := c.Patch(ctx, patched, client.MergeFrom(cm)); !k8serr.IsInvalid(err) {
+		t.Fatalf("expected an invalid error on patch, received %v", err)
+	}
+	added := client.RawPatch(types.JSONPatchType, []byte(`[{"op": "add", "path": "/metadata/finalizers/-", "value": "d"}]`))
+	if err := c.Patch(ctx, cm.DeepCopy(), added); !k8serr.IsInvalid(err) {
+		t.Fatalf("expected an invalid error on json patch, received %v", err)
+	}
+	if err := c.Get(ctx, key, cm); err != nil || len(cm.Finalizers) != 1 {
+		t.Fatalf("expected the finalizers unchanged, found %v (%v)", cm.Finalizers, err)
+	}
+
+	// The object is removed once the last finalizer is cleared.
+	released := cm.DeepCopy()
+	released.Finalizers = nil
+	if err := c.Patch(ctx, released, client.MergeFrom(cm)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, cm); !k8serr.IsNotFound(err) {
+		t.Fatalf("expected the object to be removed, received %v", err)
+	}
+}
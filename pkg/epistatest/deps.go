@@ -0,0 +1,103 @@
+package epistatest
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Deps contains the dependencies provided by the scenario, available
+// for building the reconciler under test.
+type Deps struct {
+	// Client is the client connected to the scenario fake cluster.
+	Client client.Client
+	// Scheme is the scheme built from the registered schemes.
+	Scheme *runtime.Scheme
+	// Recorder is the event recorder registered by the user, if any, otherwise a
+	// recorder discarding the events. A record.FakeRecorder could be registered to
+	// verify the events emitted (see WithDependencies).
+	Recorder record.EventRecorder
+	// Logger is the logger used by the scenario.
+	Logger logr.Logger
+	// Clock is the scenario clock (see AdvanceTime).
+	Clock clock.WithTicker
+
+	values  []any         // user registered dependencies
+	indexer *fieldIndexer // field indexes registered by the controllers
+}
+
+// Dependency looks up a value of type V among the user registered
+// dependencies (see WithDependencies).
+func Dependency[V any](deps Deps) (V, bool) {
+	for _, v := range deps.values {
+		if dep, ok := v.(V); ok {
+			return dep, true
+		}
+	}
+	return *new(V), false
+}
+
+// injectable returns the scenario dependencies, by the field type they are
+// injected into.
+func (d Deps) injectable() map[reflect.Type]any {
+	return map[reflect.Type]any{
+		reflect.TypeFor[client.Client]():        d.Client,
+		reflect.TypeFor[*runtime.Scheme]():      d.Scheme,
+		reflect.TypeFor[record.EventRecorder](): d.Recorder,
+		reflect.TypeFor[logr.Logger]():          d.Logger,
+		reflect.TypeFor[clock.PassiveClock]():   d.Clock,
+		reflect.TypeFor[clock.Clock]():          d.Clock,
+		reflect.TypeFor[clock.WithTicker]():     d.Clock,
+	}
+}
+
+// inject sets any zero field of the given struct value, including the unexported
+// and embedded ones, with the first user registered dependency assignable to it
+// or, if none, with the scenario dependency of the exact field type. It returns
+// the number of fields that received a client, either the scenario one or a user
+// registered one.
+func (d Deps) inject(v reflect.Value) int {
+	injectable := d.injectable()
+	injectedClients := 0
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		fieldType := v.Type().Field(i)
+
+		// Unexported fields cannot be set via the reflection api.
+		if !fieldType.IsExported() {
+			field = reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+		}
+		if !field.IsZero() {
+			continue
+		}
+		if fieldType.Anonymous && field.Kind() == reflect.Struct {
+			injectedClients += d.inject(field)
+			continue
+		}
+		// Skip empty interfaces, since any value would be assignable.
+		if field.Kind() == reflect.Interface && field.NumMethod() == 0 {
+			continue
+		}
+
+		dep := injectable[field.Type()]
+		for _, value := range d.values {
+			if value != nil && reflect.TypeOf(value).AssignableTo(field.Type()) {
+				dep = value
+				break
+			}
+		}
+		if dep == nil {
+			continue
+		}
+		field.Set(reflect.ValueOf(dep))
+		if _, ok := dep.(client.Client); ok {
+			injectedClients++
+		}
+	}
+	return injectedClients
+}
@@ -0,0 +1,188 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	rt "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type testDependency struct {
+	name string
+}
+
+// TestControllerWithDeps fails the reconcile if any of its dependencies
+// was not injected.
+type TestControllerWithDeps struct {
+	client   client.Client
+	scheme   *rt.Scheme
+	Recorder record.EventRecorder
+	log      logr.Logger
+	clock    clock.PassiveClock
+	dep      *testDependency
+}
+
+func (c TestControllerWithDeps) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if c.client == nil || c.scheme == nil || c.Recorder == nil || c.log.GetSink() == nil || c.clock == nil {
+		return ctrl.Result{}, fmt.Errorf("missing dependencies")
+	}
+	if c.dep == nil || c.dep.name != "custom" {
+		return ctrl.Result{}, fmt.Errorf("missing custom dependency")
+	}
+	return ctrl.Result{}, nil
+}
+
+// TestPtrController uses a pointer receiver.
+type TestPtrController struct {
+	client.Client
+	Scheme *rt.Scheme
+}
+
+func (c *TestPtrController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if c.Scheme == nil {
+		return ctrl.Result{}, fmt.Errorf("missing scheme")
+	}
+	return ctrl.Result{}, nil
+}
+
+// TestControllerWithFactory can be created only via its constructor.
+type TestControllerWithFactory struct {
+	c    client.Client
+	name string
+}
+
+func NewTestControllerWithFactory(c client.Client, name string) TestControllerWithFactory {
+	return TestControllerWithFactory{c: c, name: name}
+}
+
+func (c TestControllerWithFactory) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if c.c == nil || c.name != "custom" {
+		return ctrl.Result{}, fmt.Errorf("not built by the factory")
+	}
+	return ctrl.Result{}, nil
+}
+
+// TestControllerWithEvents emits an event for every reconciled request. The reader
+// is not injected, since it's not a scenario dependency type.
+type TestControllerWithEvents struct {
+	client.Client
+	Recorder record.EventRecorder
+	reader   client.Reader
+}
+
+func (c TestControllerWithEvents) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if c.reader != nil {
+		return ctrl.Result{}, fmt.Errorf("unexpected reader")
+	}
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, req.NamespacedName, cm); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	c.Recorder.Eventf(cm, corev1.EventTypeNormal, "Reconciled", "configmap %s reconciled", cm.Name)
+	return ctrl.Result{}, nil
+}
+
+// TestControllerWithCustomClient requires a client registered by the user.
+type TestControllerWithCustomClient struct {
+	client client.Client
+}
+
+func (c TestControllerWithCustomClient) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return ctrl.Result{}, c.client.Get(ctx, req.NamespacedName, &corev1.ConfigMap{})
+}
+
+func TestDependencyInjection(t *testing.T) {
+	t.Run("inject by type", func(t *testing.T) {
+		testScenario[TestControllerWithDeps, *corev1.ConfigMap](t, testCase{
+			testCase: New[TestControllerWithDeps, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithDependencies(&testDependency{name: "custom"}).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{}),
+		})
+	})
+	t.Run("missing custom dependency", func(t *testing.T) {
+		testScenario[TestControllerWithDeps, *corev1.ConfigMap](t, testCase{
+			testCase: New[TestControllerWithDeps, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{}, "expected to fail"),
+			expectedError: "`expected to fail` failure, expected result {Requeue:false RequeueAfter:0s} but received error: missing custom dependency",
+		})
+	})
+	t.Run("pointer reconciler", func(t *testing.T) {
+		testScenario[*TestPtrController, *corev1.ConfigMap](t, testCase{
+			testCase: New[*TestPtrController, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{}),
+		})
+	})
+	t.Run("reconciler factory", func(t *testing.T) {
+		testScenario[TestControllerWithFactory, *corev1.ConfigMap](t, testCase{
+			testCase: New[TestControllerWithFactory, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithDependencies(&testDependency{name: "custom"}).
+				WithReconcilerFactory(func(deps Deps) TestControllerWithFactory {
+					dep, _ := Dependency[*testDependency](deps)
+					return NewTestControllerWithFactory(deps.Client, dep.name)
+				}).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{}),
+		})
+	})
+	t.Run("client registered by the user", func(t *testing.T) {
+		custom := fake.NewClientBuilder().WithObjects(testScenarioBuilder{}.Build()...).Build()
+		testScenario[TestControllerWithCustomClient, *corev1.ConfigMap](t, testCase{
+			testCase: New[TestControllerWithCustomClient, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithDependencies(custom).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{}),
+		})
+	})
+	t.Run("unexported client without factory", func(t *testing.T) {
+		testScenario[TestControllerWithFactory, *corev1.ConfigMap](t, testCase{
+			testCase: New[TestControllerWithFactory, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{}, "expected to fail"),
+			expectedError: "`expected to fail` failure, expected result {Requeue:false RequeueAfter:0s} but received error: not built by the factory",
+		})
+	})
+}
+
+func TestEventRecorder(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	testScenario[TestControllerWithEvents, *corev1.ConfigMap](t, testCase{
+		testCase: New[TestControllerWithEvents, *corev1.ConfigMap]().
+			WithSchemes(corev1.AddToScheme).
+			WithDependencies(recorder).
+			Setup(testScenarioBuilder{}).
+			NextRequest("cm0", "cm").
+			ExpectResult(ctrl.Result{}).
+			ReconcileUntilE(func(c client.Client, obj *corev1.ConfigMap) error {
+				if len(recorder.Events) == 0 {
+					return fmt.Errorf("no events emitted")
+				}
+				if event := <-recorder.Events; event != "Normal Reconciled configmap cm0 reconciled" {
+					return fmt.Errorf("unexpected event %s", event)
+				}
+				return nil
+			}),
+	})
+}
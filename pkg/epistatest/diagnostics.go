@@ -0,0 +1,143 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// stepFailure is returned when a step fails, and it keeps a detailed
+// report of the scenario state, to be shown in the test output.
+type stepFailure struct {
+	err    error
+	report string
+}
+
+func (f *stepFailure) Error() string {
+	return f.err.Error()
+}
+
+func (f *stepFailure) Unwrap() error {
+	return f.err
+}
+
+// stepChanges tracks the stored objects changed during a step, so that the
+// changes could be reported on a failure without reading all the objects
+// before every step.
+type stepChanges struct {
+	before objectsSnapshot // objects state before their first change in the step
+	after  objectsSnapshot // objects latest state
+	seen   map[string]bool // objects changed in the step
+}
+
+func newStepChanges() *stepChanges {
+	return &stepChanges{
+		before: objectsSnapshot{},
+		after:  objectsSnapshot{},
+		seen:   map[string]bool{},
+	}
+}
+
+// track records the given change of an object of the specified kind.
+func (sc *stepChanges) track(gvk schema.GroupVersionKind, change objectChange) {
+	key := fmt.Sprintf("%s %s", gvk.Kind, client.ObjectKeyFromObject(change.Object()))
+	if !sc.seen[key] {
+		sc.seen[key] = true
+		if change.Old != nil {
+			sc.before[key] = objectContent(change.Old)
+		}
+	}
+	delete(sc.after, key)
+	if change.New != nil {
+		sc.after[key] = objectContent(change.New)
+	}
+}
+
+func objectContent(obj client.Object) map[string]any {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil
+	}
+	return content
+}
+
+// stepFailure wraps the step error with a report including the latest
+// version of the current request object, the changes applied to the stored
+// objects since the step start and the calls issued by the reconciler
+// during the step.
+func (s *scenario[R, T]) stepFailure(err error, calls []Call) error {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("\n--- last observed object (%s):", s.current))
+	obj, getErr := s.latestObject()
+	if getErr != nil {
+		sb.WriteString(fmt.Sprintf("\n%v\n", getErr))
+	} else if desc := formatObject(obj); desc != "" {
+		sb.WriteString(desc)
+	} else {
+		sb.WriteString("\nnot found\n")
+	}
+
+	sb.WriteString("--- changes since the step start:")
+	if changes := s.changes.before.diff(s.changes.after); changes != "" {
+		sb.WriteString(changes)
+	} else {
+		sb.WriteString("\nnone")
+	}
+
+	sb.WriteString("\n--- calls issued during the step:")
+	if len(calls) > 0 {
+		sb.WriteString(formatCalls(calls))
+	} else {
+		sb.WriteString("\nnone")
+	}
+
+	if s.dump {
+		if path, dumpErr := takeSnapshot(context.Background(), s.client, s.converter).dump(s.dumpDir); dumpErr != nil {
+			sb.WriteString(fmt.Sprintf("\n--- cluster state dump failure: %v", dumpErr))
+		} else {
+			sb.WriteString(fmt.Sprintf("\n--- cluster state dumped in %s", path))
+		}
+	}
+
+	return &stepFailure{
+		err:    err,
+		report: sb.String(),
+	}
+}
+
+// dump writes all the objects in a single yaml file in the given directory,
+// and returns its path.
+func (snapshot objectsSnapshot) dump(dir string) (string, error) {
+	keys := make([]string, 0, len(snapshot))
+	for k := range snapshot {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		data, err := yaml.Marshal(snapshot[k])
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(fmt.Sprintf("---\n# %s\n%s", k, data))
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "cluster-state.yaml")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
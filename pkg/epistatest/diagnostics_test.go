@@ -0,0 +1,85 @@
+package epistatest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestStepFailureReport(t *testing.T) {
+	dumpDir := t.TempDir()
+	s := New[TestControllerWithConflict, *corev1.ConfigMap]().
+		WithSchemes(corev1.AddToScheme).
+		WithMaxReconciles(2).
+		WithStateDump(dumpDir).
+		Setup(testScenarioBuilder{}).
+		NextRequest("cm0", "cm").
+		ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+			return obj.Data["key"] == "value"
+		}, "expected to fail")
+
+	err := s.(*scenario[TestControllerWithConflict, *corev1.ConfigMap]).test()
+	if err == nil {
+		t.Fatal("expecting an error but none received")
+	}
+	var failure *stepFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a step failure but received `%s`", err)
+	}
+	if !strings.HasPrefix(err.Error(), "`expected to fail` not satisfied, too many reconcile loops (2)") {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+
+	dumpPath := filepath.Join(dumpDir, "cluster-state.yaml")
+	for _, expected := range []string{
+		"\n--- last observed object (cm/cm0):\nmetadata:\n  creationTimestamp: ",
+		"\n  generation: 1\n  name: cm0\n  namespace: cm\n  resourceVersion: \"999\"\n  uid: ",
+		"--- changes since the step start:\nnone",
+		"--- calls issued during the step:\n  #1 get ConfigMap cm/cm0\n  #1 update ConfigMap cm/cm0\n  #2 get ConfigMap cm/cm0\n  #2 update ConfigMap cm/cm0",
+		"--- cluster state dumped in " + dumpPath,
+	} {
+		if !strings.Contains(failure.report, expected) {
+			t.Fatalf("expected `%s` in report `%s`", expected, failure.report)
+		}
+	}
+
+	dump, err := os.ReadFile(dumpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"# ConfigMap cm/cm0\n", "# ConfigMap cm/cm1\n", "# ConfigMap cm/cm2\n"} {
+		if !strings.Contains(string(dump), expected) {
+			t.Fatalf("expected `%s` in dump `%s`", expected, dump)
+		}
+	}
+}
+
+func TestStepFailureChanges(t *testing.T) {
+	s := newTestScenarioWithWatches().
+		For(&corev1.ConfigMap{}).
+		Setup(testScenarioBuilder{}).
+		ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+			return false
+		}, "expected to fail")
+
+	err := s.(*scenario[TestControllerWithWatches, *corev1.ConfigMap]).test()
+	var failure *stepFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a step failure but received `%v`", err)
+	}
+	for _, expected := range []string{
+		"\nSecret cm/cm0 created",
+		"\nSecret cm/cm1 created",
+		"\nSecret cm/cm2 created",
+		"  #3 create Secret cm/cm2",
+	} {
+		if !strings.Contains(failure.report, expected) {
+			t.Fatalf("expected `%s` in report `%s`", expected, failure.report)
+		}
+	}
+}
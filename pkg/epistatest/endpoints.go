@@ -0,0 +1,192 @@
+package epistatest
+
+import (
+	"context"
+	"slices"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// EndpointsSimulator mimics the endpoints controller: for every Service with a
+// selector, the Endpoints object with the same name lists the addresses of the
+// selected pods, split by readiness, and it's removed together with the Service.
+// The named target ports are resolved from the pods container ports. Usually enabled
+// together with the PodSimulator, to assign the pods addresses.
+func EndpointsSimulator() Simulator {
+	return &endpointsSimulator{}
+}
+
+type endpointsSimulator struct {
+	client client.Client
+}
+
+func (es *endpointsSimulator) AddToScheme(s *runtime.Scheme) error {
+	return corev1.AddToScheme(s)
+}
+
+func (es *endpointsSimulator) SetupWithManager(mgr manager.Manager) error {
+	es.client = mgr.GetClient()
+	return builder.ControllerManagedBy(mgr).
+		Named("endpoints-simulator").
+		For(&corev1.Service{}).
+		Watches(&corev1.Pod{}, handler.Funcs{
+			CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+				es.enqueueServices(ctx, q, e.Object)
+			},
+			// A pod is removed from the services selecting its old labels.
+			UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+				es.enqueueServices(ctx, q, e.ObjectOld, e.ObjectNew)
+			},
+			DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+				es.enqueueServices(ctx, q, e.Object)
+			},
+			GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+				es.enqueueServices(ctx, q, e.Object)
+			},
+		}).
+		Complete(es)
+}
+
+// enqueueServices enqueues all the services selecting any of the pods.
+func (es *endpointsSimulator) enqueueServices(ctx context.Context, q workqueue.TypedRateLimitingInterface[reconcile.Request], pods ...client.Object) {
+	services := &corev1.ServiceList{}
+	if err := es.client.List(ctx, services, client.InNamespace(pods[0].GetNamespace())); err != nil {
+		return
+	}
+	for _, svc := range services.Items {
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		selector := labels.SelectorFromSet(svc.Spec.Selector)
+		for _, pod := range pods {
+			if selector.Matches(labels.Set(pod.GetLabels())) {
+				q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}})
+				break
+			}
+		}
+	}
+}
+
+func (es *endpointsSimulator) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	svc := &corev1.Service{}
+	if err := es.client.Get(ctx, req.NamespacedName, svc); err != nil {
+		if !k8serr.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+		endpoints := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: req.Name, Namespace: req.Namespace}}
+		return reconcile.Result{}, client.IgnoreNotFound(es.client.Delete(ctx, endpoints))
+	}
+	if svc.DeletionTimestamp != nil || len(svc.Spec.Selector) == 0 {
+		return reconcile.Result{}, nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := es.client.List(ctx, pods, client.InNamespace(svc.Namespace), client.MatchingLabels(svc.Spec.Selector)); err != nil {
+		return reconcile.Result{}, err
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+
+	// The addresses are grouped by the ports resolved for their pod.
+	var subsets []corev1.EndpointSubset
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		ports := endpointPorts(svc, pod)
+		if len(ports) == 0 && len(svc.Spec.Ports) > 0 {
+			continue
+		}
+		idx := slices.IndexFunc(subsets, func(subset corev1.EndpointSubset) bool {
+			return equality.Semantic.DeepEqual(subset.Ports, ports)
+		})
+		if idx < 0 {
+			subsets = append(subsets, corev1.EndpointSubset{Ports: ports})
+			idx = len(subsets) - 1
+		}
+
+		address := corev1.EndpointAddress{
+			IP: pod.Status.PodIP,
+			TargetRef: &corev1.ObjectReference{
+				Kind:      "Pod",
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+				UID:       pod.UID,
+			},
+		}
+		if podReady(pod) {
+			subsets[idx].Addresses = append(subsets[idx].Addresses, address)
+		} else {
+			subsets[idx].NotReadyAddresses = append(subsets[idx].NotReadyAddresses, address)
+		}
+	}
+
+	endpoints := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: svc.Name, Namespace: svc.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, es.client, endpoints, func() error {
+		endpoints.Subsets = subsets
+		return nil
+	})
+	return reconcile.Result{}, err
+}
+
+// endpointPorts returns the service ports resolved for the pod. The ports whose named
+// target port is not defined by any pod container are skipped.
+func endpointPorts(svc *corev1.Service, pod *corev1.Pod) []corev1.EndpointPort {
+	var ports []corev1.EndpointPort
+	for _, p := range svc.Spec.Ports {
+		port, found := p.TargetPort.IntVal, true
+		switch {
+		case p.TargetPort.Type == intstr.String:
+			port, found = containerPort(pod, p.TargetPort.StrVal, p.Protocol)
+		case port == 0:
+			port = p.Port
+		}
+		if !found {
+			continue
+		}
+		ports = append(ports, corev1.EndpointPort{
+			Name:     p.Name,
+			Port:     port,
+			Protocol: p.Protocol,
+		})
+	}
+	return ports
+}
+
+// containerPort looks up the pod container port with the given name and protocol,
+// where an empty protocol stands for TCP.
+func containerPort(pod *corev1.Pod, name string, protocol corev1.Protocol) (int32, bool) {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			portProtocol := p.Protocol
+			if portProtocol == "" {
+				portProtocol = corev1.ProtocolTCP
+			}
+			if p.Name == name && portProtocol == protocol {
+				return p.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
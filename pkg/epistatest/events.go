@@ -0,0 +1,157 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// objectChange describes the effect of a write operation on a stored object.
+// Old is nil for a creation, while New is nil for a deletion.
+type objectChange struct {
+	Old client.Object
+	New client.Object
+}
+
+// Object returns the latest known version of the changed object.
+func (c objectChange) Object() client.Object {
+	if c.New != nil {
+		return c.New
+	}
+	return c.Old
+}
+
+// withChangeNotifier wraps the client so that notify will be invoked for every
+// stored object effectively modified by a write operation.
+func withChangeNotifier(c client.WithWatch, notify func(change objectChange)) client.WithWatch {
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			return observe(ctx, c, obj, notify, func() error {
+				return c.Create(ctx, obj, opts...)
+			})
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			return observe(ctx, c, obj, notify, func() error {
+				return c.Update(ctx, obj, opts...)
+			})
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			return observe(ctx, c, obj, notify, func() error {
+				return c.Patch(ctx, obj, patch, opts...)
+			})
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			return observe(ctx, c, obj, notify, func() error {
+				return c.Delete(ctx, obj, opts...)
+			})
+		},
+		DeleteAllOf: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteAllOfOption) error {
+			deleteAllOfOpts := &client.DeleteAllOfOptions{}
+			deleteAllOfOpts.ApplyOptions(opts)
+
+			objs, err := listObjects(ctx, c, obj, &deleteAllOfOpts.ListOptions)
+			if err != nil {
+				return err
+			}
+			if err := c.DeleteAllOf(ctx, obj, opts...); err != nil {
+				return err
+			}
+			for _, old := range objs {
+				notifyChange(old, snapshot(ctx, c, old), notify)
+			}
+			return nil
+		},
+		SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+			return observe(ctx, c, obj, notify, func() error {
+				return c.SubResource(subResourceName).Create(ctx, obj, subResource, opts...)
+			})
+		},
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			return observe(ctx, c, obj, notify, func() error {
+				return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+			})
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			return observe(ctx, c, obj, notify, func() error {
+				return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+			})
+		},
+	})
+}
+
+// observe compares the stored object before and after the write operation.
+func observe(ctx context.Context, c client.Client, obj client.Object, notify func(objectChange), write func() error) error {
+	old := snapshot(ctx, c, obj)
+	if err := write(); err != nil {
+		return err
+	}
+	notifyChange(old, snapshot(ctx, c, obj), notify)
+	return nil
+}
+
+func notifyChange(old, new client.Object, notify func(objectChange)) {
+	if old == nil && new == nil {
+		return
+	}
+	if old != nil && new != nil && old.GetResourceVersion() == new.GetResourceVersion() {
+		return
+	}
+	notify(objectChange{Old: old, New: new})
+}
+
+// snapshot returns a copy of the currently stored version of the object,
+// or nil if not found.
+func snapshot(ctx context.Context, c client.Client, obj client.Object) client.Object {
+	if obj.GetName() == "" {
+		return nil
+	}
+	stored := obj.DeepCopyObject().(client.Object)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), stored); err != nil {
+		return nil
+	}
+	return stored
+}
+
+// listObjects returns all the stored objects of the same kind of obj.
+func listObjects(ctx context.Context, c client.Client, obj client.Object, opts ...client.ListOption) ([]client.Object, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return nil, err
+	}
+	list, err := newObjectList(c, gvk)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.List(ctx, list, opts...); err != nil {
+		return nil, err
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]client.Object, 0, len(items))
+	for _, item := range items {
+		if o, ok := item.(client.Object); ok {
+			objs = append(objs, o)
+		}
+	}
+	return objs, nil
+}
+
+func newObjectList(c client.Client, gvk schema.GroupVersionKind) (client.ObjectList, error) {
+	listObj, err := c.Scheme().New(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err != nil {
+		return nil, err
+	}
+	list, ok := listObj.(client.ObjectList)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a list type", gvk.Kind+"List")
+	}
+	return list, nil
+}
@@ -0,0 +1,265 @@
+package epistatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// Verb identifies a client operation.
+type Verb string
+
+// Verbs supported for the fault injection (see FailOn). An operation on a
+// subresource other than status is identified as "<verb>/<subresource>".
+const (
+	VerbGet          Verb = "get"
+	VerbList         Verb = "list"
+	VerbCreate       Verb = "create"
+	VerbUpdate       Verb = "update"
+	VerbPatch        Verb = "patch"
+	VerbDelete       Verb = "delete"
+	VerbDeleteAllOf  Verb = "deletecollection"
+	VerbStatusUpdate Verb = "update/status"
+	VerbStatusPatch  Verb = "patch/status"
+)
+
+// subResourceVerb returns the verb of an operation on a subresource,
+// ie "update/status".
+func subResourceVerb(verb Verb, subResourceName string) Verb {
+	return Verb(fmt.Sprintf("%s/%s", verb, subResourceName))
+}
+
+// presetFault is an error built only when the fault is injected, so
+// that it could refer the resource involved in the failed call.
+type presetFault struct {
+	name  string
+	build func(gr schema.GroupResource, name string) error
+}
+
+func (f presetFault) Error() string {
+	return fmt.Sprintf("injected %s fault", f.name)
+}
+
+// ConflictFault returns an error that will be injected as a Conflict status error.
+func ConflictFault() error {
+	return presetFault{
+		name: "conflict",
+		build: func(gr schema.GroupResource, name string) error {
+			return k8serr.NewConflict(gr, name, errors.New("the object has been modified; please apply your changes to the latest version and try again"))
+		},
+	}
+}
+
+// TimeoutFault returns an error that will be injected as a Timeout status error.
+func TimeoutFault() error {
+	return presetFault{
+		name: "timeout",
+		build: func(gr schema.GroupResource, name string) error {
+			return k8serr.NewTimeoutError(fmt.Sprintf("request on %s %q did not complete", gr, name), 1)
+		},
+	}
+}
+
+// NotFoundFault returns an error that will be injected as a NotFound status error.
+func NotFoundFault() error {
+	return presetFault{
+		name: "not found",
+		build: func(gr schema.GroupResource, name string) error {
+			return k8serr.NewNotFound(gr, name)
+		},
+	}
+}
+
+// ForbiddenFault returns an error that will be injected as a Forbidden status error.
+func ForbiddenFault() error {
+	return presetFault{
+		name: "forbidden",
+		build: func(gr schema.GroupResource, name string) error {
+			return k8serr.NewForbidden(gr, name, errors.New("injected fault"))
+		},
+	}
+}
+
+// fault describes an error to be returned by the nth call matching the
+// specified verb and kind.
+type fault struct {
+	verb Verb
+	gvk  schema.GroupVersionKind
+	nth  int
+	err  error
+}
+
+// matches returns true if the fault applies to the given verb and kind.
+// An empty fault verb or kind matches any verb or kind.
+func (f fault) matches(verb Verb, gvk schema.GroupVersionKind) bool {
+	if f.verb != "" && f.verb != verb {
+		return false
+	}
+	return f.gvk.Empty() || f.gvk == gvk
+}
+
+// faultInjector records all the calls received while reconciling, and keeps
+// track of the matching ones for every configured fault.
+type faultInjector struct {
+	faults      []fault
+	calls       []int
+	recorded    []Call
+	step        string // label of the step being executed
+	reconcile   int    // index of the reconcile being executed
+	controller  string // controller being reconciled
+	reconciling bool   // a reconcile is in progress
+	scheme      *runtime.Scheme
+	mapper      meta.RESTMapper
+}
+
+func newFaultInjector(faults []fault, scheme *runtime.Scheme, mapper meta.RESTMapper) *faultInjector {
+	return &faultInjector{
+		faults: faults,
+		calls:  make([]int, len(faults)),
+		scheme: scheme,
+		mapper: mapper,
+	}
+}
+
+// inject records the current call, and returns the error to be returned
+// for it, if any. The calls issued outside a reconcile, for example by the
+// watch handlers, are neither recorded nor failed.
+func (fi *faultInjector) inject(verb Verb, obj runtime.Object, key client.ObjectKey, body []byte) error {
+	if !fi.reconciling {
+		return nil
+	}
+	gvk, err := apiutil.GVKForObject(obj, fi.scheme)
+	if err != nil {
+		return nil
+	}
+	if _, isList := obj.(client.ObjectList); isList {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+
+	var injected error
+	for i, f := range fi.faults {
+		if !f.matches(verb, gvk) {
+			continue
+		}
+		fi.calls[i]++
+		if injected == nil && (f.nth == 0 || f.nth == fi.calls[i]) {
+			injected = fi.build(f.err, gvk, key.Name)
+		}
+	}
+
+	fi.recorded = append(fi.recorded, Call{
+		Verb:       verb,
+		GVK:        gvk,
+		Key:        key,
+		Body:       body,
+		Step:       fi.step,
+		Reconcile:  fi.reconcile,
+		Controller: fi.controller,
+		Injected:   injected,
+	})
+	return injected
+}
+
+// suspend stops recording the calls and injecting the faults, until the
+// returned function is invoked.
+func (fi *faultInjector) suspend() func() {
+	reconciling := fi.reconciling
+	fi.reconciling = false
+	return func() {
+		fi.reconciling = reconciling
+	}
+}
+
+func (fi *faultInjector) build(err error, gvk schema.GroupVersionKind, name string) error {
+	preset, ok := err.(presetFault)
+	if !ok {
+		return err
+	}
+	gr := schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}
+	if mapping, mappingErr := fi.mapper.RESTMapping(gvk.GroupKind(), gvk.Version); mappingErr == nil {
+		gr = mapping.Resource.GroupResource()
+	}
+	return preset.build(gr, name)
+}
+
+// withFaults wraps the client so that the configured faults will be
+// returned instead of executing the matching calls.
+func withFaults(c client.WithWatch, fi *faultInjector) client.WithWatch {
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if err := fi.inject(VerbGet, obj, key, nil); err != nil {
+				return err
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if err := fi.inject(VerbList, list, client.ObjectKey{}, nil); err != nil {
+				return err
+			}
+			return c.List(ctx, list, opts...)
+		},
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if err := fi.inject(VerbCreate, obj, client.ObjectKeyFromObject(obj), objectBody(obj)); err != nil {
+				return err
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if err := fi.inject(VerbUpdate, obj, client.ObjectKeyFromObject(obj), objectBody(obj)); err != nil {
+				return err
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if err := fi.inject(VerbPatch, obj, client.ObjectKeyFromObject(obj), patchBody(patch, obj)); err != nil {
+				return err
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if err := fi.inject(VerbDelete, obj, client.ObjectKeyFromObject(obj), nil); err != nil {
+				return err
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+		DeleteAllOf: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteAllOfOption) error {
+			if err := fi.inject(VerbDeleteAllOf, obj, client.ObjectKey{}, nil); err != nil {
+				return err
+			}
+			return c.DeleteAllOf(ctx, obj, opts...)
+		},
+		SubResourceGet: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceGetOption) error {
+			if err := fi.inject(subResourceVerb(VerbGet, subResourceName), obj, client.ObjectKeyFromObject(obj), nil); err != nil {
+				return err
+			}
+			return c.SubResource(subResourceName).Get(ctx, obj, subResource, opts...)
+		},
+		SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+			if err := fi.inject(subResourceVerb(VerbCreate, subResourceName), obj, client.ObjectKeyFromObject(obj), objectBody(subResource)); err != nil {
+				return err
+			}
+			return c.SubResource(subResourceName).Create(ctx, obj, subResource, opts...)
+		},
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			if err := fi.inject(subResourceVerb(VerbUpdate, subResourceName), obj, client.ObjectKeyFromObject(obj), objectBody(obj)); err != nil {
+				return err
+			}
+			return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			if err := fi.inject(subResourceVerb(VerbPatch, subResourceName), obj, client.ObjectKeyFromObject(obj), patchBody(patch, obj)); err != nil {
+				return err
+			}
+			return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+		},
+	})
+}
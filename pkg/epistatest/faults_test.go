@@ -0,0 +1,85 @@
+package epistatest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestFailOn(t *testing.T) {
+	configMapGVK := corev1.SchemeGroupVersion.WithKind("ConfigMap")
+
+	cases := []testCase{
+		{
+			name: "preset fault",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				FailOn(VerbUpdate, configMapGVK, 1, ConflictFault()).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}),
+			expectedError: "`waiting condition #1` failure, expected result {Requeue:false RequeueAfter:1m0s} but received error: Operation cannot be fulfilled on configmaps \"cm0\": the object has been modified; please apply your changes to the latest version and try again",
+		},
+		{
+			name: "transient fault",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				FailOn(VerbUpdate, configMapGVK, 1, TimeoutFault()).
+				FailOn(VerbUpdate, configMapGVK, 2, ConflictFault()).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectError(k8serr.IsTimeout).
+				ExpectError(k8serr.IsConflict).
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}),
+		},
+		{
+			name: "fault on every call",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithMaxReconciles(3).
+				FailOn(VerbGet, schema.GroupVersionKind{}, 0, errors.New("boom")).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}),
+			expectedError: "`waiting condition #1` not satisfied, too many reconcile loops (3), last reconcile error: boom",
+		},
+		{
+			name: "other kinds not affected",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				FailOn(VerbUpdate, corev1.SchemeGroupVersion.WithKind("Secret"), 0, ForbiddenFault()).
+				FailOn(VerbStatusUpdate, configMapGVK, 0, ForbiddenFault()).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}),
+		},
+		{
+			name: "steps not affected",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				FailOn(VerbGet, configMapGVK, 0, NotFoundFault()).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return obj.Name == "cm0" && reconciles(obj) == 0
+				}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestControllerWithRequeueAfter, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
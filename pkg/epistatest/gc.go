@@ -0,0 +1,212 @@
+package epistatest
+
+import (
+	"context"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// garbageCollector emulates the cluster garbage collector, by deleting the objects
+// whose owners are gone, and by completing the foreground and orphan deletions.
+type garbageCollector struct {
+	client    client.Client
+	converter *versionConverter // storage versions of the multi-version kinds, if any
+}
+
+// storedObject is a stored object, with its kind.
+type storedObject struct {
+	gvk schema.GroupVersionKind
+	obj client.Object
+}
+
+// collect executes the first garbage collection action found, and returns true
+// if the scenario cluster was changed.
+func (gc *garbageCollector) collect(ctx context.Context) bool {
+	objs := gc.objects(ctx)
+	for _, o := range objs {
+		var err error
+		var changed bool
+		switch {
+		case o.obj.GetDeletionTimestamp() != nil && slices.Contains(o.obj.GetFinalizers(), metav1.FinalizerOrphanDependents):
+			changed, err = gc.orphanDependents(ctx, objs, o)
+		case o.obj.GetDeletionTimestamp() != nil && slices.Contains(o.obj.GetFinalizers(), metav1.FinalizerDeleteDependents):
+			changed, err = gc.deleteDependents(ctx, objs, o)
+		case o.obj.GetDeletionTimestamp() == nil && len(o.obj.GetOwnerReferences()) > 0:
+			changed, err = gc.verifyOwners(ctx, objs, o)
+		}
+		if err == nil && changed {
+			return true
+		}
+	}
+	return false
+}
+
+// orphanDependents removes the owner references to the owner from all its dependents,
+// before releasing the owner.
+func (gc *garbageCollector) orphanDependents(ctx context.Context, objs []storedObject, owner storedObject) (bool, error) {
+	if deps := dependents(objs, owner); len(deps) > 0 {
+		d := deps[0]
+		var refs []metav1.OwnerReference
+		for _, ref := range d.obj.GetOwnerReferences() {
+			if !refersTo(ref, d.obj, owner) {
+				refs = append(refs, ref)
+			}
+		}
+		d.obj.SetOwnerReferences(refs)
+		return true, gc.client.Update(ctx, d.obj)
+	}
+	controllerutil.RemoveFinalizer(owner.obj, metav1.FinalizerOrphanDependents)
+	return true, gc.client.Update(ctx, owner.obj)
+}
+
+// deleteDependents deletes all the dependents of the owner, which is released only
+// when there are no more dependents blocking its deletion.
+func (gc *garbageCollector) deleteDependents(ctx context.Context, objs []storedObject, owner storedObject) (bool, error) {
+	blocked := false
+	for _, d := range dependents(objs, owner) {
+		if d.obj.GetDeletionTimestamp() == nil {
+			return true, gc.client.Delete(ctx, d.obj, gc.propagationPolicy(objs, d))
+		}
+		for _, ref := range d.obj.GetOwnerReferences() {
+			if refersTo(ref, d.obj, owner) && ref.BlockOwnerDeletion != nil && *ref.BlockOwnerDeletion {
+				blocked = true
+			}
+		}
+	}
+	if blocked {
+		return false, nil
+	}
+	controllerutil.RemoveFinalizer(owner.obj, metav1.FinalizerDeleteDependents)
+	return true, gc.client.Update(ctx, owner.obj)
+}
+
+// verifyOwners deletes the object if none of its owners exists anymore, or if the
+// remaining ones are waiting for the deletion of their dependents. Otherwise the
+// references to the missing owners are removed.
+func (gc *garbageCollector) verifyOwners(ctx context.Context, objs []storedObject, o storedObject) (bool, error) {
+	var solid []metav1.OwnerReference
+	waiting := false
+	for _, ref := range o.obj.GetOwnerReferences() {
+		owner := findOwner(objs, o.obj, ref)
+		switch {
+		case owner == nil:
+		case owner.obj.GetDeletionTimestamp() != nil && slices.Contains(owner.obj.GetFinalizers(), metav1.FinalizerDeleteDependents):
+			waiting = true
+		default:
+			solid = append(solid, ref)
+		}
+	}
+
+	switch {
+	case len(solid) == len(o.obj.GetOwnerReferences()):
+		return false, nil
+	case len(solid) > 0:
+		o.obj.SetOwnerReferences(solid)
+		return true, gc.client.Update(ctx, o.obj)
+	case waiting:
+		return true, gc.client.Delete(ctx, o.obj, gc.propagationPolicy(objs, o))
+	default:
+		return true, gc.client.Delete(ctx, o.obj, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	}
+}
+
+// propagationPolicy returns the policy used for deleting a dependent of an owner
+// being deleted in foreground: the deletion is propagated in foreground only if the
+// dependent has in turn some dependents.
+func (gc *garbageCollector) propagationPolicy(objs []storedObject, o storedObject) client.PropagationPolicy {
+	if len(dependents(objs, o)) > 0 {
+		return client.PropagationPolicy(metav1.DeletePropagationForeground)
+	}
+	return client.PropagationPolicy(metav1.DeletePropagationBackground)
+}
+
+// objects returns all the stored objects, for every kind registered in the scheme.
+func (gc *garbageCollector) objects(ctx context.Context) []storedObject {
+	var objs []storedObject
+	for _, gvk := range storedKinds(gc.client.Scheme(), gc.converter) {
+		obj, err := gc.client.Scheme().New(gvk)
+		if err != nil {
+			continue
+		}
+		items, err := listObjects(ctx, gc.client, obj.(client.Object))
+		if err != nil {
+			continue
+		}
+		for _, item := range items {
+			objs = append(objs, storedObject{gvk: gvk, obj: item})
+		}
+	}
+	return objs
+}
+
+// dependents returns all the objects referring the owner.
+func dependents(objs []storedObject, owner storedObject) []storedObject {
+	var deps []storedObject
+	for _, o := range objs {
+		for _, ref := range o.obj.GetOwnerReferences() {
+			if refersTo(ref, o.obj, owner) {
+				deps = append(deps, o)
+				break
+			}
+		}
+	}
+	return deps
+}
+
+// findOwner returns the owner referenced by the dependent, or nil if not found.
+func findOwner(objs []storedObject, dependent client.Object, ref metav1.OwnerReference) *storedObject {
+	for i := range objs {
+		if refersTo(ref, dependent, objs[i]) {
+			return &objs[i]
+		}
+	}
+	return nil
+}
+
+// refersTo returns true if the owner reference of the dependent identifies the owner.
+// The owner must be in the same namespace of the dependent, or cluster scoped. The uid
+// is compared only when set in both the reference and the owner.
+func refersTo(ref metav1.OwnerReference, dependent client.Object, owner storedObject) bool {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil || gv.Group != owner.gvk.Group || ref.Kind != owner.gvk.Kind || ref.Name != owner.obj.GetName() {
+		return false
+	}
+	if owner.obj.GetNamespace() != "" && owner.obj.GetNamespace() != dependent.GetNamespace() {
+		return false
+	}
+	return ref.UID == "" || owner.obj.GetUID() == "" || ref.UID == owner.obj.GetUID()
+}
+
+// withDeletePropagation wraps the client to honor the foreground and orphan propagation
+// policies when deleting an object, by adding the finalizers then processed by the
+// garbage collector. The default policy is background.
+func withDeletePropagation(c client.WithWatch) client.WithWatch {
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			deleteOpts := &client.DeleteOptions{}
+			deleteOpts.ApplyOptions(opts)
+
+			var finalizer string
+			switch {
+			case deleteOpts.PropagationPolicy == nil:
+			case *deleteOpts.PropagationPolicy == metav1.DeletePropagationForeground:
+				finalizer = metav1.FinalizerDeleteDependents
+			case *deleteOpts.PropagationPolicy == metav1.DeletePropagationOrphan:
+				finalizer = metav1.FinalizerOrphanDependents
+			}
+			if stored := snapshot(ctx, c, obj); finalizer != "" && stored != nil && stored.GetDeletionTimestamp() == nil {
+				if controllerutil.AddFinalizer(stored, finalizer) {
+					if err := c.Update(ctx, stored); err != nil {
+						return err
+					}
+				}
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	})
+}
@@ -0,0 +1,119 @@
+package epistatest
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func ownedBy(names ...string) []v1.OwnerReference {
+	var refs []v1.OwnerReference
+	for _, name := range names {
+		refs = append(refs, v1.OwnerReference{
+			APIVersion:         "v1",
+			Kind:               "ConfigMap",
+			Name:               name,
+			BlockOwnerDeletion: ptr.To(true),
+		})
+	}
+	return refs
+}
+
+// ownersSetup creates the secret s0 owned by cm0, and s1 owned by both cm0 and cm1.
+// The secret s0 could be also protected by a finalizer.
+func ownersSetup(finalizers ...string) func() []client.Object {
+	return func() []client.Object {
+		return append(testScenarioBuilder{}.Build(),
+			&corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "s0", Namespace: "cm", OwnerReferences: ownedBy("cm0"), Finalizers: finalizers}},
+			&corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "s1", Namespace: "cm", OwnerReferences: ownedBy("cm0", "cm1")}},
+		)
+	}
+}
+
+func getSecret(c client.Client, name string) *corev1.Secret {
+	secret := &corev1.Secret{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "cm"}, secret); err != nil {
+		return nil
+	}
+	return secret
+}
+
+func deleteWithPolicy(policy v1.DeletionPropagation) func(client.Client, *corev1.ConfigMap) {
+	return func(c client.Client, obj *corev1.ConfigMap) {
+		_ = c.Delete(context.Background(), obj, client.PropagationPolicy(policy))
+	}
+}
+
+func TestGarbageCollector(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "background deletion",
+			testCase: newTestScenario().
+				WithGarbageCollector().
+				SetupObjects(ownersSetup()).
+				DeleteObject("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					s1 := getSecret(client, "s1")
+					return getSecret(client, "s0") == nil && s1 != nil && len(s1.OwnerReferences) == 1 && s1.OwnerReferences[0].Name == "cm1"
+				}),
+		},
+		{
+			name: "no garbage collector",
+			testCase: newTestScenario().
+				SetupObjects(ownersSetup()).
+				DeleteObject("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return getSecret(client, "s0") == nil
+				}, "s0 deleted"),
+			expectedError: "`s0 deleted` not satisfied, too many reconcile loops (20)",
+		},
+		{
+			name: "foreground deletion",
+			testCase: newTestScenario().
+				WithGarbageCollector().
+				SetupObjects(ownersSetup("keep")).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return true
+				}).
+				Then(deleteWithPolicy(v1.DeletePropagationForeground)).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					s0 := getSecret(client, "s0")
+					return controllerutil.ContainsFinalizer(obj, v1.FinalizerDeleteDependents) && s0 != nil && s0.DeletionTimestamp != nil
+				}, "blocked by s0").
+				Then(func(c client.Client, obj *corev1.ConfigMap) {
+					s0 := getSecret(c, "s0")
+					s0.Finalizers = nil
+					_ = c.Update(context.Background(), s0)
+				}).
+				ExpectDeleted(),
+		},
+		{
+			name: "orphan deletion",
+			testCase: newTestScenario().
+				WithGarbageCollector().
+				SetupObjects(ownersSetup()).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return true
+				}).
+				Then(deleteWithPolicy(v1.DeletePropagationOrphan)).
+				ExpectDeleted().
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					s0, s1 := getSecret(client, "s0"), getSecret(client, "s1")
+					return s0 != nil && len(s0.OwnerReferences) == 0 && s1 != nil && len(s1.OwnerReferences) == 1
+				}, "orphans"),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestController, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// objectsSnapshot contains all the stored objects, indexed by kind and key.
+type objectsSnapshot map[string]map[string]any
+
+// takeSnapshot reads all the stored objects, for every kind registered in the scheme.
+func takeSnapshot(ctx context.Context, c client.Client, vc *versionConverter) objectsSnapshot {
+	snapshot := objectsSnapshot{}
+	for _, gvk := range storedKinds(c.Scheme(), vc) {
+		obj, err := c.Scheme().New(gvk)
+		if err != nil {
+			continue
+		}
+		objs, err := listObjects(ctx, c, obj.(client.Object))
+		if err != nil {
+			continue
+		}
+		for _, o := range objs {
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o)
+			if err != nil {
+				continue
+			}
+			snapshot[fmt.Sprintf("%s %s", gvk.Kind, client.ObjectKeyFromObject(o))] = content
+		}
+	}
+	return snapshot
+}
+
+// storedKinds returns all the kinds of the scheme that could be stored, ie
+// having a related list kind. A kind with a storage version (see WithStorageVersion)
+// is returned only in that version, since all its objects are stored in it.
+func storedKinds(scheme *runtime.Scheme, vc *versionConverter) []schema.GroupVersionKind {
+	var kinds []schema.GroupVersionKind
+	for gvk, t := range scheme.AllKnownTypes() {
+		if strings.HasSuffix(gvk.Kind, "List") || gvk.Version == runtime.APIVersionInternal {
+			continue
+		}
+		if storage, found := vc.storageVersion(gvk.GroupKind()); found && storage != gvk {
+			continue
+		}
+		if !scheme.Recognizes(gvk.GroupVersion().WithKind(gvk.Kind + "List")) {
+			continue
+		}
+		if _, ok := reflect.New(t).Interface().(client.Object); !ok {
+			continue
+		}
+		kinds = append(kinds, gvk)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].String() < kinds[j].String()
+	})
+	return kinds
+}
+
+// diff reports the objects changed between the two snapshots.
+func (before objectsSnapshot) diff(after objectsSnapshot) string {
+	keys := map[string]struct{}{}
+	for k := range before {
+		keys[k] = struct{}{}
+	}
+	for k := range after {
+		keys[k] = struct{}{}
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var sb strings.Builder
+	for _, k := range sortedKeys {
+		oldObj, found := before[k]
+		newObj, stillFound := after[k]
+		switch {
+		case !found:
+			sb.WriteString(fmt.Sprintf("\n%s created", k))
+		case !stillFound:
+			sb.WriteString(fmt.Sprintf("\n%s deleted", k))
+		default:
+			if d := cmp.Diff(oldObj, newObj); d != "" {
+				sb.WriteString(fmt.Sprintf("\n%s changed (-before +after):\n%s", k, d))
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,51 @@
+package epistatest
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestIdempotencyCheck(t *testing.T) {
+	t.Run("idempotent", func(t *testing.T) {
+		testScenario[TestControllerWithWatches, *corev1.ConfigMap](t, testCase{
+			testCase: newTestScenarioWithWatches().
+				WithIdempotencyCheck().
+				For(&corev1.ConfigMap{}).
+				Owns(&corev1.Secret{}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0") && secretExists(client, "cm1") && secretExists(client, "cm2")
+				}).
+				ExpectWrites(3),
+		})
+	})
+
+	t.Run("not idempotent", func(t *testing.T) {
+		s := New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+			WithSchemes(corev1.AddToScheme).
+			WithIdempotencyCheck().
+			Setup(testScenarioBuilder{}).
+			NextRequest("cm0", "cm").
+			ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+				return reconciles(obj) == 1
+			}, "reconciled once")
+
+		err := s.(*scenario[TestControllerWithRequeueAfter, *corev1.ConfigMap]).test()
+		if err == nil {
+			t.Fatal("expecting an idempotency error but none received")
+		}
+		for _, expected := range []string{
+			"`reconciled once` failure, idempotency check failure, 1 writes issued after convergence\n  #2 update ConfigMap cm/cm0\n",
+			"ConfigMap cm/cm0 changed (-before +after):",
+			`"reconciles": string("1")`,
+			`"reconciles": string("2")`,
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Fatalf("expected `%s` in error `%s`", expected, err.Error())
+			}
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package epistatest
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// invariant is a named condition verified after every reconcile.
+type invariant[T runtime.Object] struct {
+	name  string
+	check func(c client.Client, obj T) error
+}
+
+// formatObject returns the yaml representation of the object, to be
+// appended to an error message.
+func formatObject(obj runtime.Object) string {
+	if o, ok := obj.(client.Object); !ok || o.GetName() == "" {
+		return ""
+	}
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return ""
+	}
+	return "\n" + string(data)
+}
@@ -0,0 +1,118 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func atMostReconciles(n int) func(c client.Client) error {
+	return func(c client.Client) error {
+		cm := &corev1.ConfigMap{}
+		if err := c.Get(context.Background(), types.NamespacedName{Name: "cm0", Namespace: "cm"}, cm); err != nil {
+			return err
+		}
+		if reconciles(cm) > n {
+			return fmt.Errorf("found %d reconciles", reconciles(cm))
+		}
+		return nil
+	}
+}
+
+func TestInvariants(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "invariant satisfied",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithInvariant("at most two reconciles", atMostReconciles(2)).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				AdvanceTime(time.Minute).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 2
+				}),
+		},
+		{
+			name: "invariant violated",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithInvariant("at most one reconcile", atMostReconciles(1)).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				AdvanceTime(time.Minute).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 2
+				}),
+			expectedError: "`waiting condition #3` failure, invariant `at most one reconcile` violated after reconcile #2 (cm/cm0): found 2 reconciles\n" +
+				"data:\n  reconciles: \"2\"\nmetadata:\n",
+		},
+		{
+			name: "always violated",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilIdle("settle").
+				Always(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 0
+				}, "never reconciled"),
+			expectedError: "`settle` failure, invariant `never reconciled` violated after reconcile #1 (cm/cm0): condition not satisfied",
+		},
+		{
+			name: "never violated",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilIdle("settle").
+				Never(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 0
+				}).
+				Never(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}),
+			expectedError: "`settle` failure, invariant `never #1` violated after reconcile #1 (cm/cm0): condition satisfied",
+		},
+		{
+			name: "step invariants",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilIdle().
+				Always(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				AdvanceTime(time.Minute).
+				ReconcileUntilIdle().
+				Never(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.testCase.(*scenario[TestControllerWithRequeueAfter, *corev1.ConfigMap]).test()
+			if err == nil && tc.expectedError != "" {
+				t.Fatalf("expecting error `%s` but none received", tc.expectedError)
+			}
+			if err != nil && (tc.expectedError == "" || !strings.HasPrefix(err.Error(), tc.expectedError)) {
+				t.Fatalf("expected error: `%s`, but received `%s", tc.expectedError, err.Error())
+			}
+		})
+	}
+}
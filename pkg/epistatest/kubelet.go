@@ -0,0 +1,131 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// PodSimulator mimics the kubelet: every pending pod is immediately started, and
+// it becomes running and ready, with an IP address derived from its name. The
+// pods already terminated are not affected.
+func PodSimulator() Simulator {
+	return &podSimulator{}
+}
+
+type podSimulator struct {
+	client client.Client
+}
+
+func (ps *podSimulator) AddToScheme(s *runtime.Scheme) error {
+	return corev1.AddToScheme(s)
+}
+
+func (ps *podSimulator) SetupWithManager(mgr manager.Manager) error {
+	ps.client = mgr.GetClient()
+	return builder.ControllerManagedBy(mgr).
+		Named("pod-simulator").
+		For(&corev1.Pod{}).
+		Complete(ps)
+}
+
+func (ps *podSimulator) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	pod := &corev1.Pod{}
+	if err := ps.client.Get(ctx, req.NamespacedName, pod); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if pod.DeletionTimestamp != nil || (pod.Status.Phase != "" && pod.Status.Phase != corev1.PodPending) {
+		return reconcile.Result{}, nil
+	}
+
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.PodIP = podIP(pod.Namespace, pod.Name)
+	pod.Status.PodIPs = []corev1.PodIP{{IP: pod.Status.PodIP}}
+	pod.Status.Conditions = []corev1.PodCondition{
+		{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+		{Type: corev1.PodInitialized, Status: corev1.ConditionTrue},
+		{Type: corev1.ContainersReady, Status: corev1.ConditionTrue},
+		{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+	}
+	pod.Status.ContainerStatuses = nil
+	for _, c := range pod.Spec.Containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:    c.Name,
+			Image:   c.Image,
+			Ready:   true,
+			Started: ptr.To(true),
+			State: corev1.ContainerState{
+				Running: &corev1.ContainerStateRunning{},
+			},
+		})
+	}
+	return reconcile.Result{}, ps.client.Status().Update(ctx, pod)
+}
+
+// podIP returns a stable IP address for the pod.
+func podIP(namespace, name string) string {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(namespace + "/" + name))
+	h := hasher.Sum32()
+	return fmt.Sprintf("10.%d.%d.%d", (h>>16)&0xff, (h>>8)&0xff, max(h&0xff, 1))
+}
+
+// podReady returns true if the pod is reporting the Ready condition.
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// NodeSimulator mimics the kubelet node status updates: every node not yet
+// reporting the Ready condition becomes ready.
+func NodeSimulator() Simulator {
+	return &nodeSimulator{}
+}
+
+type nodeSimulator struct {
+	client client.Client
+}
+
+func (ns *nodeSimulator) AddToScheme(s *runtime.Scheme) error {
+	return corev1.AddToScheme(s)
+}
+
+func (ns *nodeSimulator) SetupWithManager(mgr manager.Manager) error {
+	ns.client = mgr.GetClient()
+	return builder.ControllerManagedBy(mgr).
+		Named("node-simulator").
+		For(&corev1.Node{}).
+		Complete(ns)
+}
+
+func (ns *nodeSimulator) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	node := &corev1.Node{}
+	if err := ns.client.Get(ctx, req.NamespacedName, node); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return reconcile.Result{}, nil
+		}
+	}
+
+	node.Status.Conditions = append(node.Status.Conditions, corev1.NodeCondition{
+		Type:    corev1.NodeReady,
+		Status:  corev1.ConditionTrue,
+		Reason:  "KubeletReady",
+		Message: "kubelet is posting ready status",
+	})
+	return reconcile.Result{}, ns.client.Status().Update(ctx, node)
+}
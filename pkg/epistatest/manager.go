@@ -0,0 +1,282 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	ctrlcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeManager is a minimal manager.Manager, just enough to let a controller
+// builder complete its setup. The controllers added are started against a fake
+// cache, which captures the event handlers registered by their watches, so that
+// the scenario events could be delivered to them. The requests enqueued by the
+// handlers are routed to the scenario queue, while the controller workers never
+// run. Any other manager method will panic if invoked.
+type fakeManager struct {
+	manager.Manager
+
+	deps      Deps
+	mapper    meta.RESTMapper
+	cache     *watchCache
+	runnables []manager.Runnable
+}
+
+func newFakeManager(deps Deps, mapper meta.RESTMapper) *fakeManager {
+	return &fakeManager{
+		deps:   deps,
+		mapper: mapper,
+		cache:  &watchCache{},
+	}
+}
+
+func (m *fakeManager) GetClient() client.Client {
+	return m.deps.Client
+}
+
+func (m *fakeManager) GetAPIReader() client.Reader {
+	return m.deps.Client
+}
+
+func (m *fakeManager) GetScheme() *runtime.Scheme {
+	return m.deps.Scheme
+}
+
+func (m *fakeManager) GetRESTMapper() meta.RESTMapper {
+	return m.mapper
+}
+
+// GetCache returns the cache capturing the watches of the controllers.
+func (m *fakeManager) GetCache() cache.Cache {
+	return m.cache
+}
+
+func (m *fakeManager) GetFieldIndexer() client.FieldIndexer {
+	return m.deps.indexer
+}
+
+func (m *fakeManager) GetEventRecorderFor(name string) record.EventRecorder {
+	return m.deps.Recorder
+}
+
+func (m *fakeManager) GetLogger() logr.Logger {
+	return m.deps.Logger
+}
+
+// GetControllerOptions disables the controller names validation, since
+// the same controller will be set up again for every scenario.
+func (m *fakeManager) GetControllerOptions() config.Controller {
+	return config.Controller{
+		SkipNameValidation: ptr.To(true),
+	}
+}
+
+// Add keeps the controller, since the builder registers the watches
+// only after having added it.
+func (m *fakeManager) Add(runnable manager.Runnable) error {
+	m.runnables = append(m.runnables, runnable)
+	return nil
+}
+
+// startControllers starts all the added controllers until the given context is
+// done, routing their requests to the queue. It returns the watches registered
+// by the controllers, and the highest number of workers configured.
+func (m *fakeManager) startControllers(ctx context.Context, queue *requestQueue) ([]watchSource, int, error) {
+	workers := 1
+	for _, r := range m.runnables {
+		if _, ok := r.(ctrlcontroller.TypedController[reconcile.Request]); !ok {
+			continue
+		}
+		q, n, err := routeQueue(r, queue, ctx.Done())
+		if err != nil {
+			return nil, 0, err
+		}
+		workers = max(workers, n)
+
+		started := make(chan error, 1)
+		go func() {
+			started <- r.Start(ctx)
+		}()
+		// Once a worker waits for a request, all the watches are registered.
+		select {
+		case <-q.started:
+		case err := <-started:
+			return nil, 0, fmt.Errorf("controller start failure: %v", err)
+		}
+	}
+	return m.cache.sources(), workers, nil
+}
+
+// routeQueue replaces the queue constructor of a controller built by the
+// controller-runtime, so that the requests enqueued by its watches are routed
+// to the scenario queue, which applies the rate limiter of the controller options.
+// The number of workers configured in the options is returned as well.
+func routeQueue(runnable manager.Runnable, queue *requestQueue, done <-chan struct{}) (*controllerQueue, int, error) {
+	q := &controllerQueue{requestQueue: queue, done: done, started: make(chan struct{})}
+	newQueue := func(name string, rateLimiter workqueue.TypedRateLimiter[reconcile.Request]) workqueue.TypedRateLimitingInterface[reconcile.Request] {
+		queue.useRateLimiter(rateLimiter)
+		return q
+	}
+
+	ctrl := reflect.ValueOf(runnable)
+	if ctrl.Kind() != reflect.Ptr || ctrl.Elem().Kind() != reflect.Struct {
+		return nil, 0, fmt.Errorf("unsupported controller %T", runnable)
+	}
+	field := ctrl.Elem().FieldByName("NewQueue")
+	if !field.IsValid() || !field.CanSet() || field.Type() != reflect.TypeOf(newQueue) {
+		return nil, 0, fmt.Errorf("unsupported controller %T", runnable)
+	}
+	field.Set(reflect.ValueOf(newQueue))
+
+	workers := 1
+	if field := ctrl.Elem().FieldByName("MaxConcurrentReconciles"); field.IsValid() && field.Kind() == reflect.Int {
+		workers = int(field.Int())
+	}
+	return q, workers, nil
+}
+
+// controllerQueue is the queue of a controller started by the fake manager,
+// forwarding the requests to the scenario queue. The controller workers are
+// kept waiting until the scenario ends, since the requests are reconciled
+// only by the scenario steps.
+type controllerQueue struct {
+	*requestQueue
+
+	done    <-chan struct{}
+	once    sync.Once
+	started chan struct{} // closed when the first worker waits for a request
+}
+
+// Get is invoked only by the controller workers, and it blocks until the
+// scenario ends.
+func (q *controllerQueue) Get() (reconcile.Request, bool) {
+	q.once.Do(func() {
+		close(q.started)
+	})
+	<-q.done
+	return reconcile.Request{}, true
+}
+
+// ShutDown leaves the scenario queue untouched when the controller stops.
+func (q *controllerQueue) ShutDown() {}
+
+func (q *controllerQueue) ShutDownWithDrain() {}
+
+// watchCache is the cache of the controllers started by the fake manager. It
+// provides a new informer to every watch source, to capture the event handler
+// registered. The informers are always synced, since the scenario notifies the
+// initial objects by itself.
+type watchCache struct {
+	cache.Cache
+
+	mu        sync.Mutex
+	informers []*watchInformer
+}
+
+func (c *watchCache) GetInformer(ctx context.Context, obj client.Object, opts ...cache.InformerGetOption) (cache.Informer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := &watchInformer{object: obj}
+	c.informers = append(c.informers, i)
+	return i, nil
+}
+
+func (c *watchCache) WaitForCacheSync(ctx context.Context) bool {
+	return true
+}
+
+// sources returns the watches captured by the informers.
+func (c *watchCache) sources() []watchSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sources []watchSource
+	for _, i := range c.informers {
+		for _, h := range i.handlers {
+			sources = append(sources, watchSource{
+				object:   i.object,
+				informer: h,
+			})
+		}
+	}
+	return sources
+}
+
+// watchInformer captures the event handlers registered by a watch source.
+type watchInformer struct {
+	cache.Informer
+
+	object   client.Object
+	handlers []toolscache.ResourceEventHandler
+}
+
+func (i *watchInformer) AddEventHandler(handler toolscache.ResourceEventHandler) (toolscache.ResourceEventHandlerRegistration, error) {
+	i.handlers = append(i.handlers, handler)
+	return nil, nil
+}
+
+// fieldIndexer collects the indexes registered by the controllers, and adds them to
+// a fake client sharing the objects of the scenario client, which serves its lists.
+type fieldIndexer struct {
+	scheme  *runtime.Scheme
+	builder func() *fake.ClientBuilder // builds a client sharing the scenario objects
+	indexes []fieldIndex
+	indexed client.WithWatch // client with the registered indexes, if any
+}
+
+type fieldIndex struct {
+	obj          client.Object
+	field        string
+	extractValue client.IndexerFunc
+}
+
+func (f *fieldIndexer) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	gvk, err := apiutil.GVKForObject(obj, f.scheme)
+	if err != nil {
+		return err
+	}
+	for _, index := range f.indexes {
+		if index.field == field && index.obj.GetObjectKind().GroupVersionKind() == gvk {
+			return fmt.Errorf("indexer conflict: field %s for %v is already indexed", field, gvk)
+		}
+	}
+	indexObj := obj.DeepCopyObject().(client.Object)
+	indexObj.GetObjectKind().SetGroupVersionKind(gvk)
+	f.indexes = append(f.indexes, fieldIndex{obj: indexObj, field: field, extractValue: extractValue})
+
+	builder := f.builder()
+	for _, index := range f.indexes {
+		builder = builder.WithIndex(index.obj, index.field, index.extractValue)
+	}
+	f.indexed = builder.Build()
+	return nil
+}
+
+// withFieldIndexes wraps the client so that the lists are served by the client with
+// the indexes registered, to support the field selectors on the indexed fields.
+func withFieldIndexes(c client.WithWatch, indexer *fieldIndexer) client.WithWatch {
+	return interceptor.NewClient(c, interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if indexer.indexed != nil {
+				return indexer.indexed.List(ctx, list, opts...)
+			}
+			return c.List(ctx, list, opts...)
+		},
+	})
+}
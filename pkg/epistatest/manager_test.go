@@ -0,0 +1,158 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func (s TestControllerWithWatches) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		WithEventFilter(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetName() != "cm2"
+		})).
+		Complete(s)
+}
+
+// setupWithRateLimiter configures a rate limiter delaying the first retry by one second.
+func setupWithRateLimiter(r TestControllerWithWatches, mgr manager.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.ConfigMap{}).
+		WithOptions(ctrlcontroller.Options{
+			RateLimiter: workqueue.NewTypedItemExponentialFailureRateLimiter[reconcile.Request](time.Second, time.Minute),
+		}).
+		Complete(r)
+}
+
+// setupWithIndex indexes the config maps by name.
+func setupWithIndex(r TestControllerWithWatches, mgr manager.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.ConfigMap{}, "name", func(obj client.Object) []string {
+		return []string{obj.GetName()}
+	}); err != nil {
+		return err
+	}
+	return r.SetupWithManager(mgr)
+}
+
+func TestFromSetupWithManager(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "captured watches",
+			testCase: newTestScenarioWithWatches().
+				FromSetupWithManager(TestControllerWithWatches.SetupWithManager).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0") && secretExists(client, "cm1")
+				}).
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "cm0", Namespace: "cm"}}
+					_ = client.Delete(context.Background(), secret)
+				}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0")
+				}, "secret recreated"),
+		},
+		{
+			name: "captured event filter",
+			testCase: newTestScenarioWithWatches().
+				FromSetupWithManager(TestControllerWithWatches.SetupWithManager).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm2")
+				}, "wait for cm2 secret"),
+			expectedError: "`wait for cm2 secret` not satisfied, no pending reconcile requests",
+		},
+		{
+			name: "rate limiter from the options",
+			testCase: newTestScenarioWithWatches().
+				FromSetupWithManager(setupWithRateLimiter).
+				FailOn(VerbCreate, corev1.SchemeGroupVersion.WithKind("Secret"), 1, TimeoutFault()).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0")
+				}, "cm0 secret"),
+			expectedError: "`cm0 secret` not satisfied, next reconcile scheduled in 1s",
+		},
+		{
+			name: "rate limited request",
+			testCase: newTestScenarioWithWatches().
+				FromSetupWithManager(setupWithRateLimiter).
+				FailOn(VerbCreate, corev1.SchemeGroupVersion.WithKind("Secret"), 1, TimeoutFault()).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntilIdle().
+				AdvanceTime(time.Second).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0")
+				}, "cm0 secret"),
+		},
+		{
+			name: "field index",
+			testCase: newTestScenarioWithWatches().
+				FromSetupWithManager(setupWithIndex).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(c client.Client, obj *corev1.ConfigMap) bool {
+					cms := &corev1.ConfigMapList{}
+					if err := c.List(context.Background(), cms, client.MatchingFields{"name": "cm1"}); err != nil {
+						return false
+					}
+					return len(cms.Items) == 1 && cms.Items[0].Name == "cm1"
+				}),
+		},
+		{
+			name: "field index conflict",
+			testCase: newTestScenarioWithWatches().
+				FromSetupWithManager(func(r TestControllerWithWatches, mgr manager.Manager) error {
+					if err := setupWithIndex(r, mgr); err != nil {
+						return err
+					}
+					return setupWithIndex(r, mgr)
+				}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntilIdle(),
+			expectedError: "reconciler setup failure: indexer conflict: field name for /v1, Kind=ConfigMap is already indexed",
+		},
+		{
+			name: "setup failure",
+			testCase: newTestScenarioWithWatches().
+				FromSetupWithManager(func(r TestControllerWithWatches, mgr manager.Manager) error {
+					return fmt.Errorf("boom")
+				}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return true
+				}),
+			expectedError: "reconciler setup failure: boom",
+		},
+		{
+			name: "no watches",
+			testCase: newTestScenarioWithWatches().
+				FromSetupWithManager(func(r TestControllerWithWatches, mgr manager.Manager) error {
+					return nil
+				}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return true
+				}),
+			expectedError: "no watches found in the reconciler setup",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestControllerWithWatches, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
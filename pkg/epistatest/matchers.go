@@ -0,0 +1,132 @@
+package epistatest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/andfasano/epistatest/pkg/conditions"
+)
+
+// Matcher verifies a condition on an object, returning an error that
+// explains why the object does not match.
+type Matcher func(obj client.Object) error
+
+// AllOf matches when all the matchers match, and reports the first mismatch.
+func AllOf(matchers ...Matcher) Matcher {
+	return func(obj client.Object) error {
+		for _, m := range matchers {
+			if err := m(obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// AnyOf matches when at least one of the matchers matches, and reports all the
+// mismatches otherwise.
+func AnyOf(matchers ...Matcher) Matcher {
+	return func(obj client.Object) error {
+		var errs []string
+		for _, m := range matchers {
+			err := m(obj)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("none matched: %s", strings.Join(errs, "; "))
+	}
+}
+
+// Not matches when the given matcher does not match. The description is used
+// to report the unexpected match.
+func Not(m Matcher, description string) Matcher {
+	return func(obj client.Object) error {
+		if m(obj) == nil {
+			return errors.New(description)
+		}
+		return nil
+	}
+}
+
+// HasLabel matches when the label is present and, if specified, has the given value.
+func HasLabel(key string, value ...string) Matcher {
+	return func(obj client.Object) error {
+		return hasEntry("label", obj.GetLabels(), key, value)
+	}
+}
+
+// HasAnnotation matches when the annotation is present and, if specified, has
+// the given value.
+func HasAnnotation(key string, value ...string) Matcher {
+	return func(obj client.Object) error {
+		return hasEntry("annotation", obj.GetAnnotations(), key, value)
+	}
+}
+
+func hasEntry(kind string, entries map[string]string, key string, value []string) error {
+	current, found := entries[key]
+	if !found {
+		return fmt.Errorf("%s `%s` not found", kind, key)
+	}
+	if len(value) > 0 && current != value[0] {
+		return fmt.Errorf("%s `%s` is `%s`, expected `%s`", kind, key, current, value[0])
+	}
+	return nil
+}
+
+// HasFinalizer matches when the finalizer is present.
+func HasFinalizer(finalizer string) Matcher {
+	return func(obj client.Object) error {
+		if !slices.Contains(obj.GetFinalizers(), finalizer) {
+			return fmt.Errorf("finalizer `%s` not found in %v", finalizer, obj.GetFinalizers())
+		}
+		return nil
+	}
+}
+
+// HasOwnerReference matches when the object is owned by an object with the
+// given kind and name.
+func HasOwnerReference(kind string, name string) Matcher {
+	return func(obj client.Object) error {
+		for _, ref := range obj.GetOwnerReferences() {
+			if ref.Kind == kind && ref.Name == name {
+				return nil
+			}
+		}
+		return fmt.Errorf("owner reference %s/%s not found", kind, name)
+	}
+}
+
+// HasControllerReference matches when the object is controlled by an object
+// with the given kind and name.
+func HasControllerReference(kind string, name string) Matcher {
+	return func(obj client.Object) error {
+		ref := metav1.GetControllerOf(obj)
+		if ref == nil {
+			return fmt.Errorf("controller reference %s/%s not found", kind, name)
+		}
+		if ref.Kind != kind || ref.Name != name {
+			return fmt.Errorf("controller reference is %s/%s, expected %s/%s", ref.Kind, ref.Name, kind, name)
+		}
+		return nil
+	}
+}
+
+// HasCondition matches when the object reports, in its status.conditions field,
+// a condition of the given type and status and, if specified, reason. If the same
+// type is reported more than once, the latest one is used.
+func HasCondition(conditionType string, status metav1.ConditionStatus, reason ...string) Matcher {
+	return func(obj client.Object) error {
+		if len(reason) > 0 {
+			return conditions.Check(obj, conditionType, status, reason[0])
+		}
+		return conditions.Check(obj, conditionType, status, "")
+	}
+}
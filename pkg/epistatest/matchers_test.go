@@ -0,0 +1,138 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestMatchers(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        "secret",
+			Namespace:   "cm",
+			Labels:      map[string]string{"app": "test"},
+			Annotations: map[string]string{"note": "value"},
+			Finalizers:  []string{"test/finalizer"},
+			OwnerReferences: []v1.OwnerReference{
+				{Kind: "ConfigMap", Name: "cm0"},
+				{Kind: "ConfigMap", Name: "cm1", Controller: ptr.To(true)},
+			},
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: v1.ObjectMeta{Name: "node"},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Reason: "KubeletNotReady", Message: "kubelet stopped"},
+				{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+			},
+		},
+	}
+
+	cases := []struct {
+		name          string
+		matcher       Matcher
+		obj           client.Object
+		expectedError string
+	}{
+		{name: "label", matcher: HasLabel("app"), obj: secret},
+		{name: "label value", matcher: HasLabel("app", "test"), obj: secret},
+		{name: "missing label", matcher: HasLabel("tier"), obj: secret, expectedError: "label `tier` not found"},
+		{name: "wrong label value", matcher: HasLabel("app", "prod"), obj: secret, expectedError: "label `app` is `test`, expected `prod`"},
+		{name: "annotation", matcher: HasAnnotation("note", "value"), obj: secret},
+		{name: "missing annotation", matcher: HasAnnotation("other"), obj: secret, expectedError: "annotation `other` not found"},
+		{name: "finalizer", matcher: HasFinalizer("test/finalizer"), obj: secret},
+		{name: "missing finalizer", matcher: HasFinalizer("other"), obj: secret, expectedError: "finalizer `other` not found in [test/finalizer]"},
+		{name: "owner reference", matcher: HasOwnerReference("ConfigMap", "cm0"), obj: secret},
+		{name: "missing owner reference", matcher: HasOwnerReference("ConfigMap", "cm2"), obj: secret, expectedError: "owner reference ConfigMap/cm2 not found"},
+		{name: "controller reference", matcher: HasControllerReference("ConfigMap", "cm1"), obj: secret},
+		{name: "wrong controller reference", matcher: HasControllerReference("ConfigMap", "cm0"), obj: secret, expectedError: "controller reference is ConfigMap/cm1, expected ConfigMap/cm0"},
+		{name: "missing controller reference", matcher: HasControllerReference("ConfigMap", "cm0"), obj: node, expectedError: "controller reference ConfigMap/cm0 not found"},
+		{name: "condition", matcher: HasCondition("MemoryPressure", v1.ConditionFalse), obj: node},
+		{name: "condition reason", matcher: HasCondition("Ready", v1.ConditionFalse, "KubeletNotReady"), obj: node},
+		{name: "wrong condition reason", matcher: HasCondition("Ready", v1.ConditionFalse, "NodeStatusUnknown"), obj: node, expectedError: "condition `Ready` reason is `KubeletNotReady`, expected `NodeStatusUnknown`"},
+		{name: "wrong condition status", matcher: HasCondition("Ready", v1.ConditionTrue), obj: node, expectedError: "condition `Ready` is `False` (kubelet stopped), expected `True`"},
+		{name: "missing condition", matcher: HasCondition("DiskPressure", v1.ConditionFalse), obj: node, expectedError: "condition `DiskPressure` not found"},
+		{name: "no conditions", matcher: HasCondition("Ready", v1.ConditionTrue), obj: secret, expectedError: "condition `Ready` not found"},
+		{name: "all of", matcher: AllOf(HasLabel("app"), HasFinalizer("test/finalizer")), obj: secret},
+		{name: "all of mismatch", matcher: AllOf(HasLabel("app"), HasFinalizer("other"), HasLabel("tier")), obj: secret, expectedError: "finalizer `other` not found in [test/finalizer]"},
+		{name: "any of", matcher: AnyOf(HasLabel("tier"), HasLabel("app")), obj: secret},
+		{name: "any of mismatch", matcher: AnyOf(HasLabel("tier"), HasFinalizer("other")), obj: secret, expectedError: "none matched: label `tier` not found; finalizer `other` not found in [test/finalizer]"},
+		{name: "not", matcher: Not(HasLabel("tier"), "unexpected tier label"), obj: secret},
+		{name: "not mismatch", matcher: Not(HasLabel("app"), "unexpected app label"), obj: secret, expectedError: "unexpected app label"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.matcher(tc.obj)
+			if err == nil && tc.expectedError != "" {
+				t.Fatalf("expecting error `%s` but none received", tc.expectedError)
+			}
+			if err != nil && err.Error() != tc.expectedError {
+				t.Fatalf("expected error: `%s`, but received `%s", tc.expectedError, err.Error())
+			}
+		})
+	}
+}
+
+func TestReconcileUntilWithReason(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "error condition satisfied",
+			testCase: newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}).
+				Owns(&corev1.Secret{}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntilE(func(c client.Client, obj *corev1.ConfigMap) error {
+					secret := &corev1.Secret{}
+					if err := c.Get(context.Background(), types.NamespacedName{Name: "cm2", Namespace: "cm"}, secret); err != nil {
+						return err
+					}
+					return HasControllerReference("ConfigMap", "cm2")(secret)
+				}),
+		},
+		{
+			name: "error condition not satisfied",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilE(func(client client.Client, obj *corev1.ConfigMap) error {
+					if reconciles(obj) != 2 {
+						return fmt.Errorf("found %d reconciles", reconciles(obj))
+					}
+					return nil
+				}, "reconciled twice"),
+			expectedError: "`reconciled twice` not satisfied (found 1 reconciles), next reconcile scheduled in " + time.Minute.String(),
+		},
+		{
+			name: "matcher not satisfied",
+			testCase: newTestScenario().
+				WithMaxReconciles(3).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilMatch(AllOf(HasLabel("app"), HasFinalizer("test/finalizer")), "labelled"),
+			expectedError: "`labelled` not satisfied (label `app` not found), too many reconcile loops (3)",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			switch tc.testCase.(type) {
+			case *scenario[TestController, *corev1.ConfigMap]:
+				testScenario[TestController, *corev1.ConfigMap](t, tc)
+			case *scenario[TestControllerWithRequeueAfter, *corev1.ConfigMap]:
+				testScenario[TestControllerWithRequeueAfter, *corev1.ConfigMap](t, tc)
+			default:
+				testScenario[TestControllerWithWatches, *corev1.ConfigMap](t, tc)
+			}
+		})
+	}
+}
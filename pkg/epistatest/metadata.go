@@ -0,0 +1,159 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func (s *scenario[R, T]) ExpectObservedGenerationCurrent(labels ...string) _reconcileAction[T] {
+	return s.ReconcileUntilMatch(generationObserved, labels...)
+}
+
+// generationObserved matches when the generation reported in the object status
+// is the current one.
+func generationObserved(obj client.Object) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	observed, found, err := unstructured.NestedInt64(content, "status", "observedGeneration")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("status.observedGeneration not found")
+	}
+	if observed != obj.GetGeneration() {
+		return fmt.Errorf("observed generation %d, expected %d", observed, obj.GetGeneration())
+	}
+	return nil
+}
+
+// initObjectMetadata sets the metadata fields assigned by the API server when
+// the object is created, if not already set.
+func initObjectMetadata(obj client.Object, clock clock.Clock) {
+	if obj.GetUID() == "" {
+		obj.SetUID(uuid.NewUUID())
+	}
+	if creation := obj.GetCreationTimestamp(); creation.IsZero() {
+		obj.SetCreationTimestamp(metav1.NewTime(clock.Now()))
+	}
+	if obj.GetGeneration() == 0 {
+		obj.SetGeneration(1)
+	}
+}
+
+// withObjectMetadata wraps the client to maintain the metadata fields like the API
+// server: uid, creation timestamp and generation are set on creation, and then the
+// generation is incremented on every change not limited to the metadata or the status,
+// or when the deletion timestamp is set. The status is never considered, even for
+// the kinds without a status subresource.
+func withObjectMetadata(c client.WithWatch, clock clock.Clock) client.WithWatch {
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			obj.SetUID("")
+			obj.SetCreationTimestamp(metav1.Time{})
+			obj.SetGeneration(0)
+			initObjectMetadata(obj, clock)
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if old := snapshot(ctx, c, obj); old != nil {
+				generation, err := nextGeneration(old, obj)
+				if err != nil {
+					return err
+				}
+				obj.SetGeneration(generation)
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			patchOpts := &client.PatchOptions{}
+			patchOpts.ApplyOptions(opts)
+			old := snapshot(ctx, c, obj)
+			if old == nil || len(patchOpts.DryRun) > 0 {
+				return c.Patch(ctx, obj, patch, opts...)
+			}
+			// The patch is applied locally to compute the generation, and the patched
+			// object is stored in place of the patch if the generation changes.
+			patched, err := applyPatch(old, obj, patch)
+			if err != nil {
+				return err
+			}
+			generation, err := nextGeneration(old, patched)
+			if err != nil {
+				return err
+			}
+			if patched.GetGeneration() == generation {
+				return c.Patch(ctx, obj, patch, opts...)
+			}
+			patched.SetGeneration(generation)
+			if err := c.Update(ctx, patched, patchUpdateOptions(patchOpts)); err != nil {
+				return err
+			}
+			return c.Get(ctx, client.ObjectKeyFromObject(patched), obj)
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			old := snapshot(ctx, c, obj)
+			if err := c.Delete(ctx, obj, opts...); err != nil || old == nil {
+				return err
+			}
+			return markDeleted(ctx, c, old, obj)
+		},
+	})
+}
+
+// nextGeneration returns the generation of the object written over the stored one,
+// which is incremented on every change not limited to the metadata or the status.
+func nextGeneration(stored, obj client.Object) (int64, error) {
+	changed, err := specChanged(stored, obj)
+	if err != nil {
+		return 0, err
+	}
+	if changed {
+		return stored.GetGeneration() + 1, nil
+	}
+	return stored.GetGeneration(), nil
+}
+
+// markDeleted increments the generation of an object whose deletion was just started,
+// since the deletion timestamp is set by the fake client itself.
+func markDeleted(ctx context.Context, c client.Client, old client.Object, obj client.Object) error {
+	stored := snapshot(ctx, c, old)
+	if stored == nil || old.GetDeletionTimestamp() != nil || stored.GetDeletionTimestamp() == nil {
+		return nil
+	}
+	stored.SetGeneration(old.GetGeneration() + 1)
+	if err := c.Update(ctx, stored); err != nil {
+		return err
+	}
+	return c.Get(ctx, client.ObjectKeyFromObject(stored), obj)
+}
+
+// specChanged returns true if any field other than metadata and status differs.
+func specChanged(old, new client.Object) (bool, error) {
+	oldContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+	if err != nil {
+		return false, err
+	}
+	newContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(new)
+	if err != nil {
+		return false, err
+	}
+	for _, content := range []map[string]any{oldContent, newContent} {
+		delete(content, "metadata")
+		delete(content, "status")
+		delete(content, "apiVersion")
+		delete(content, "kind")
+	}
+	return !equality.Semantic.DeepEqual(oldContent, newContent), nil
+}
@@ -0,0 +1,153 @@
+package epistatest
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testingclock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestObjectMetadata(t *testing.T) {
+	ctx := context.Background()
+	clock := testingclock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := withObjectMetadata(fake.NewClientBuilder().WithStatusSubresource(&appsv1.Deployment{}).Build(), clock)
+
+	deploy := &appsv1.Deployment{ObjectMeta: v1.ObjectMeta{Name: "deploy", Namespace: "ns", Generation: 5, Finalizers: []string{"keep"}}}
+	if err := c.Create(ctx, deploy); err != nil {
+		t.Fatal(err)
+	}
+	if deploy.UID == "" || !deploy.CreationTimestamp.Time.Equal(clock.Now()) || deploy.Generation != 1 {
+		t.Fatalf("unexpected metadata after creation: %+v", deploy.ObjectMeta)
+	}
+
+	for _, step := range []struct {
+		name       string
+		write      func() error
+		generation int64
+		writes     int
+	}{
+		{
+			name: "spec update",
+			write: func() error {
+				deploy.Spec.MinReadySeconds = 10
+				return c.Update(ctx, deploy)
+			},
+			generation: 2,
+			writes:     1,
+		},
+		{
+			name: "metadata update",
+			write: func() error {
+				deploy.Labels = map[string]string{"app": "web"}
+				return c.Update(ctx, deploy)
+			},
+			generation: 2,
+			writes:     1,
+		},
+		{
+			name: "status update",
+			write: func() error {
+				deploy.Status.ObservedGeneration = 2
+				return c.Status().Update(ctx, deploy)
+			},
+			generation: 2,
+			writes:     1,
+		},
+		{
+			name: "spec patch",
+			write: func() error {
+				original := deploy.DeepCopy()
+				deploy.Spec.MinReadySeconds = 20
+				return c.Patch(ctx, deploy, client.MergeFrom(original))
+			},
+			generation: 3,
+			writes:     1,
+		},
+		{
+			name: "stale generation update",
+			write: func() error {
+				deploy.Generation = 1
+				deploy.Spec.Paused = true
+				return c.Update(ctx, deploy)
+			},
+			generation: 4,
+			writes:     1,
+		},
+		{
+			name: "deletion",
+			write: func() error {
+				return c.Delete(ctx, deploy)
+			},
+			generation: 5,
+			// The deletion timestamp is set by the fake client.
+			writes: 2,
+		},
+	} {
+		before, _ := strconv.Atoi(deploy.ResourceVersion)
+		if err := step.write(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(deploy), deploy); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if after, _ := strconv.Atoi(deploy.ResourceVersion); after-before != step.writes {
+			t.Fatalf("%s: expected %d writes, found resource version %d after %d", step.name, step.writes, after, before)
+		}
+		if deploy.Generation != step.generation {
+			t.Fatalf("%s: expected generation %d, found %d", step.name, step.generation, deploy.Generation)
+		}
+	}
+}
+
+func TestExpectObservedGenerationCurrent(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "generation observed",
+			testCase: newTestScenarioWithWorkloads(&appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{Name: "deploy", Namespace: "ns"},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1},
+			}).
+				NextRequest("deploy", "ns").
+				ExpectObservedGenerationCurrent(),
+		},
+		{
+			name: "stale generation",
+			testCase: newTestScenarioWithWorkloads(&appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{Name: "deploy", Namespace: "ns", Generation: 3},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 2},
+			}).
+				NextRequest("deploy", "ns").
+				ExpectObservedGenerationCurrent("observed"),
+			expectedError: "`observed` not satisfied (observed generation 2, expected 3), too many reconcile loops (20)",
+		},
+		{
+			name: "no observed generation",
+			testCase: newTestScenarioWithWorkloads(&appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{Name: "deploy", Namespace: "ns"},
+			}).
+				NextRequest("deploy", "ns").
+				ExpectObservedGenerationCurrent("observed"),
+			expectedError: "`observed` not satisfied (status.observedGeneration not found), too many reconcile loops (20)",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestController, *appsv1.Deployment](t, tc)
+		})
+	}
+}
+
+func newTestScenarioWithWorkloads(objs ...client.Object) _reconcileNextRequest[*appsv1.Deployment] {
+	return New[TestController, *appsv1.Deployment]().
+		WithSchemes(corev1.AddToScheme, appsv1.AddToScheme).
+		SetupObjects(func() []client.Object {
+			return objs
+		})
+}
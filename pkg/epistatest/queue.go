@@ -0,0 +1,243 @@
+package epistatest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// requestQueue is a deterministic implementation of the controller-runtime
+// workqueue, where the delayed requests are evaluated against the scenario
+// clock. Like for the real workqueue, a request is never queued more than
+// once, and a request added while being reconciled will be processed again
+// once done. Rate limiting is applied only with the rate limiter configured in
+// the controller options (see FromSetupWithManager), otherwise the rate limited
+// requests are immediately available.
+type requestQueue struct {
+	clock       clock.PassiveClock
+	rateLimiter workqueue.TypedRateLimiter[reconcile.Request] // optional, delaying the rate limited requests
+
+	queue      []reconcile.Request             // requests ready to be processed, in order
+	dirty      sets.Set[reconcile.Request]     // requests that need to be processed
+	processing sets.Set[reconcile.Request]     // requests currently being processed
+	waiting    map[reconcile.Request]time.Time // delayed requests
+	requeues   map[reconcile.Request]int       // rate limited requests counter
+	shutdown   bool
+}
+
+var _ workqueue.TypedRateLimitingInterface[reconcile.Request] = &requestQueue{}
+
+func newRequestQueue(clock clock.PassiveClock) *requestQueue {
+	return &requestQueue{
+		clock:      clock,
+		dirty:      sets.New[reconcile.Request](),
+		processing: sets.New[reconcile.Request](),
+		waiting:    make(map[reconcile.Request]time.Time),
+		requeues:   make(map[reconcile.Request]int),
+	}
+}
+
+func (q *requestQueue) Add(req reconcile.Request) {
+	if q.shutdown || q.dirty.Has(req) {
+		return
+	}
+	q.dirty.Insert(req)
+	if q.processing.Has(req) {
+		return
+	}
+	q.queue = append(q.queue, req)
+}
+
+func (q *requestQueue) Len() int {
+	q.promote()
+	return len(q.queue)
+}
+
+// Get returns the next ready request without blocking: if none is available,
+// the returned request will be empty.
+func (q *requestQueue) Get() (reconcile.Request, bool) {
+	q.promote()
+	if len(q.queue) == 0 {
+		return reconcile.Request{}, q.shutdown
+	}
+	req := q.queue[0]
+	q.queue = q.queue[1:]
+	q.processing.Insert(req)
+	q.dirty.Delete(req)
+	return req, false
+}
+
+func (q *requestQueue) Done(req reconcile.Request) {
+	q.processing.Delete(req)
+	if q.dirty.Has(req) {
+		q.queue = append(q.queue, req)
+	}
+}
+
+func (q *requestQueue) ShutDown() {
+	q.shutdown = true
+}
+
+func (q *requestQueue) ShutDownWithDrain() {
+	q.shutdown = true
+}
+
+func (q *requestQueue) ShuttingDown() bool {
+	return q.shutdown
+}
+
+// AddAfter schedules the request once the given delay will be elapsed.
+// An earlier deadline, if already present, is preserved.
+func (q *requestQueue) AddAfter(req reconcile.Request, d time.Duration) {
+	if q.shutdown {
+		return
+	}
+	if d <= 0 {
+		q.Add(req)
+		return
+	}
+	deadline := q.clock.Now().Add(d)
+	if current, found := q.waiting[req]; found && !deadline.Before(current) {
+		return
+	}
+	q.waiting[req] = deadline
+}
+
+func (q *requestQueue) AddRateLimited(req reconcile.Request) {
+	if q.rateLimiter != nil {
+		q.AddAfter(req, q.rateLimiter.When(req))
+		return
+	}
+	q.requeues[req]++
+	q.Add(req)
+}
+
+func (q *requestQueue) Forget(req reconcile.Request) {
+	if q.rateLimiter != nil {
+		q.rateLimiter.Forget(req)
+	}
+	delete(q.requeues, req)
+}
+
+func (q *requestQueue) NumRequeues(req reconcile.Request) int {
+	if q.rateLimiter != nil {
+		return q.rateLimiter.NumRequeues(req)
+	}
+	return q.requeues[req]
+}
+
+// useRateLimiter applies the rate limiter of a controller, unless it's the
+// controller-runtime default one: like in any other scenario, the failed requests
+// are then retried immediately, without having to advance the scenario clock.
+func (q *requestQueue) useRateLimiter(rateLimiter workqueue.TypedRateLimiter[reconcile.Request]) {
+	if reflect.DeepEqual(rateLimiter, workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]()) {
+		return
+	}
+	q.rateLimiter = rateLimiter
+}
+
+// promote moves the delayed requests whose deadline was elapsed into
+// the queue, sorted by deadline.
+func (q *requestQueue) promote() {
+	now := q.clock.Now()
+
+	var ready []reconcile.Request
+	for req, deadline := range q.waiting {
+		if !deadline.After(now) {
+			ready = append(ready, req)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		di, dj := q.waiting[ready[i]], q.waiting[ready[j]]
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return ready[i].String() < ready[j].String()
+	})
+
+	for _, req := range ready {
+		delete(q.waiting, req)
+		q.Add(req)
+	}
+}
+
+// take marks the given request as being processed, removing it from the queue
+// if it was ready.
+func (q *requestQueue) take(req reconcile.Request) {
+	q.promote()
+	for i, r := range q.queue {
+		if r == req {
+			q.queue = append(q.queue[:i], q.queue[i+1:]...)
+			break
+		}
+	}
+	q.processing.Insert(req)
+	q.dirty.Delete(req)
+}
+
+// requeue schedules again the request according to the outcome of its
+// latest reconcile, mimicking the controller-runtime behavior.
+func (q *requestQueue) requeue(req reconcile.Request, result reconcile.Result, err error) {
+	switch {
+	case errors.Is(err, reconcile.TerminalError(nil)):
+		// Terminal errors are not retried.
+	case err != nil:
+		q.AddRateLimited(req)
+	case result.RequeueAfter > 0:
+		q.Forget(req)
+		q.AddAfter(req, result.RequeueAfter)
+	case result.Requeue:
+		q.AddRateLimited(req)
+	default:
+		q.Forget(req)
+	}
+}
+
+// delay returns the time left before the request deadline, if the request
+// was scheduled in the future and it is not already ready.
+func (q *requestQueue) delay(req reconcile.Request) (time.Duration, bool) {
+	q.promote()
+	deadline, found := q.waiting[req]
+	if !found || q.dirty.Has(req) {
+		return 0, false
+	}
+	return deadline.Sub(q.clock.Now()), true
+}
+
+// ready returns true if the request is waiting to be processed.
+func (q *requestQueue) ready(req reconcile.Request) bool {
+	q.promote()
+	return q.dirty.Has(req)
+}
+
+// pending describes why no request is currently ready to be processed.
+func (q *requestQueue) pending() error {
+	return pendingRequests(q)
+}
+
+// pendingRequests describes why no request is currently ready to be processed
+// in any of the given queues, reporting the earliest deadline.
+func pendingRequests(queues ...*requestQueue) error {
+	var next time.Time
+	var now time.Time
+	for _, q := range queues {
+		q.promote()
+		for _, deadline := range q.waiting {
+			if next.IsZero() || deadline.Before(next) {
+				next = deadline
+				now = q.clock.Now()
+			}
+		}
+	}
+	if next.IsZero() {
+		return fmt.Errorf("no pending reconcile requests")
+	}
+	return fmt.Errorf("next reconcile scheduled in %s", next.Sub(now))
+}
@@ -0,0 +1,110 @@
+package epistatest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	testingclock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newTestRequest(name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "ns"}}
+}
+
+func TestRequestQueue(t *testing.T) {
+	cases := []struct {
+		name             string
+		run              func(q *requestQueue, clock *testingclock.FakeClock)
+		expectedRequests []string
+	}{
+		{
+			name: "fifo",
+			run: func(q *requestQueue, clock *testingclock.FakeClock) {
+				q.Add(newTestRequest("a"))
+				q.Add(newTestRequest("b"))
+			},
+			expectedRequests: []string{"a", "b"},
+		},
+		{
+			name: "dedup",
+			run: func(q *requestQueue, clock *testingclock.FakeClock) {
+				q.Add(newTestRequest("a"))
+				q.Add(newTestRequest("b"))
+				q.Add(newTestRequest("a"))
+				q.AddRateLimited(newTestRequest("b"))
+			},
+			expectedRequests: []string{"a", "b"},
+		},
+		{
+			name: "added while processing",
+			run: func(q *requestQueue, clock *testingclock.FakeClock) {
+				q.Add(newTestRequest("a"))
+				q.Add(newTestRequest("b"))
+				req, _ := q.Get()
+				q.Add(req)
+				q.Add(req)
+				if q.Len() != 1 {
+					t.Fatalf("expected only one ready request, found %d", q.Len())
+				}
+				q.Done(req)
+			},
+			expectedRequests: []string{"b", "a"},
+		},
+		{
+			name: "add after",
+			run: func(q *requestQueue, clock *testingclock.FakeClock) {
+				q.AddAfter(newTestRequest("a"), 2*time.Minute)
+				q.AddAfter(newTestRequest("b"), time.Minute)
+				q.AddAfter(newTestRequest("c"), time.Hour)
+				clock.Step(2 * time.Minute)
+			},
+			expectedRequests: []string{"b", "a"},
+		},
+		{
+			name: "add after keeps the earliest deadline",
+			run: func(q *requestQueue, clock *testingclock.FakeClock) {
+				q.AddAfter(newTestRequest("a"), time.Minute)
+				q.AddAfter(newTestRequest("a"), time.Hour)
+				clock.Step(time.Minute)
+			},
+			expectedRequests: []string{"a"},
+		},
+		{
+			name: "requeue after terminal error",
+			run: func(q *requestQueue, clock *testingclock.FakeClock) {
+				q.requeue(newTestRequest("a"), reconcile.Result{}, reconcile.TerminalError(errors.New("boom")))
+				q.requeue(newTestRequest("b"), reconcile.Result{}, errors.New("boom"))
+				q.requeue(newTestRequest("c"), reconcile.Result{}, nil)
+				q.requeue(newTestRequest("d"), reconcile.Result{Requeue: true}, nil)
+				q.requeue(newTestRequest("e"), reconcile.Result{RequeueAfter: time.Second}, nil)
+			},
+			expectedRequests: []string{"b", "d"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := testingclock.NewFakeClock(time.Now())
+			q := newRequestQueue(clock)
+			tc.run(q, clock)
+
+			var requests []string
+			for q.Len() > 0 {
+				req, _ := q.Get()
+				requests = append(requests, req.Name)
+				q.Done(req)
+			}
+			if len(requests) != len(tc.expectedRequests) {
+				t.Fatalf("expected requests %v, but found %v", tc.expectedRequests, requests)
+			}
+			for i := range requests {
+				if requests[i] != tc.expectedRequests[i] {
+					t.Fatalf("expected requests %v, but found %v", tc.expectedRequests, requests)
+				}
+			}
+		})
+	}
+}
@@ -2,10 +2,17 @@ package epistatest
 
 import (
 	"testing"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/andfasano/epistatest/pkg/conditions"
 )
 
 // New creates a new Scenario instance for the configured reconciler and
@@ -25,6 +32,103 @@ type Scenario[R reconcile.Reconciler, T client.Object] interface {
 	// executed continuosly before declaring a failure when testing
 	// a reconcile condition (see ReconcileUntil).
 	WithMaxReconciles(n int) Scenario[R, T]
+	// Allows to specify how to build the reconciler instance. If not set, a new
+	// instance of R will be created, and its fields will be automatically injected
+	// by type with the scenario dependencies (see Deps) and the user registered ones.
+	WithReconcilerFactory(func(deps Deps) R) Scenario[R, T]
+	// Registers additional values that will be injected in the reconciler fields
+	// with a compatible type. They take precedence over the scenario dependencies,
+	// which are injected only in the fields of their exact type, and they are also
+	// available to the reconciler factory (see Dependency).
+	WithDependencies(values ...any) Scenario[R, T]
+	// Declares the main resource type reconciled, similarly to the controller-runtime
+	// builder. Once at least one watch is declared, the reconcile requests are
+	// automatically enqueued by the events generated by any write on the scenario
+	// client (including the initial objects), instead of being explicitly set
+	// via NextRequest.
+	For(obj client.Object, predicates ...predicate.Predicate) Scenario[R, T]
+	// Declares a resource type owned by the main resource type, so that
+	// any change will trigger a reconcile for its controller owner (see For).
+	Owns(obj client.Object, predicates ...predicate.Predicate) Scenario[R, T]
+	// Declares a generic watch on the resource type obj, where the events
+	// are mapped to reconcile requests by the specified handler.
+	Watches(obj client.Object, eventHandler handler.EventHandler, predicates ...predicate.Predicate) Scenario[R, T]
+	// Configures the watches by invoking the reconciler own setup against a fake manager,
+	// so that the events will be routed exactly as in the production controller.
+	// The setup is invoked with the reconciler instance used by the scenario, and
+	// it's usually specified with a method expression, ie:
+	//   FromSetupWithManager(MyReconciler.SetupWithManager)
+	// The controllers added are started against a fake cache, capturing the watches
+	// registered by the controller builder, while any other manager feature is not
+	// supported. Their workers never run, since the requests are reconciled only by
+	// the steps. The RateLimiter option, if set, delays the failed and requeued
+	// requests on the scenario clock, and MaxConcurrentReconciles sets the requests
+	// reconciled in a row by ReconcileAll (see WithController).
+	FromSetupWithManager(setup func(r R, mgr manager.Manager) error) Scenario[R, T]
+	// Enables an additional reconcile after every satisfied ReconcileUntil step, to verify
+	// that the reconciler is idempotent: the step fails if any write was issued, or if any
+	// stored object was changed (a diff will be reported). The predicates should then
+	// describe a converged state.
+	WithIdempotencyCheck() Scenario[R, T]
+	// Registers a condition that must hold after every reconcile, for the whole scenario.
+	// The test fails as soon as the check returns an error, reporting the reconcile index
+	// and the current request object.
+	WithInvariant(name string, check func(client client.Client) error) Scenario[R, T]
+	// When a step fails, all the objects stored in the scenario cluster will be written
+	// as yaml in the specified directory (or in a test temporary directory, if empty).
+	WithStateDump(dir string) Scenario[R, T]
+	// Records in the given history the transitions of the conditions reported by the
+	// current request object, observed after every reconcile. The history is reset
+	// at the beginning of every run.
+	WithConditionHistory(history *conditions.History) Scenario[R, T]
+	// Enables the simulation of some behaviors of a real cluster, so that the reconciler
+	// could observe their effects (see DeploymentSimulator, ReplicaSetSimulator, PodSimulator,
+	// JobSimulator, EndpointsSimulator and NodeSimulator). The simulators react to the
+	// cluster changes, and they are stepped until settled at the beginning of the scenario
+	// and after every reconcile, action or clock advance: the test fails if the cluster
+	// does not settle. The simulators use the scenario clock (see AdvanceTime), and the
+	// types they handle are automatically registered in the scenario scheme.
+	WithSimulators(simulators ...Simulator) Scenario[R, T]
+	// Enables the emulation of the garbage collector: the objects whose owners (see
+	// ownerReferences) are all gone are deleted, and the foreground and orphan propagation
+	// policies are honored when deleting an owner. In a foreground deletion, the owner is
+	// kept until all its dependents with blockOwnerDeletion are removed. The garbage collector
+	// runs whenever the simulators are stepped (see WithSimulators).
+	WithGarbageCollector() Scenario[R, T]
+	// Loads the CustomResourceDefinition manifests found in the given files or directories,
+	// so that the custom resources created or updated, both by the reconciler and by the steps
+	// (including the initial objects), are defaulted, pruned of the unknown fields and
+	// validated against the structural schema of their version, like in a real API server.
+	// The x-kubernetes-validations CEL rules are evaluated too, including the transition
+	// rules referring oldSelf, which are skipped on creation. When the status subresource
+	// is enabled, an update of the main resource ignores the status, and an update of the
+	// status ignores everything else. An invalid write is rejected with an Invalid error,
+	// and it makes the test fail when issued by the setup or by a Then.
+	// The types must be registered anyway in the scenario scheme (see WithSchemes).
+	WithCRDs(paths ...string) Scenario[R, T]
+	// Registers the admission webhooks of some resource types, invoked in-process on
+	// every create, update, patch and delete issued on the scenario cluster, including the
+	// creation of the initial objects. Like in the API server, the defaulters run after the
+	// CRD schema defaulting, and the validators after the CRD schema validation (see WithCRDs).
+	// A denial is returned to the caller as an API server error, and it makes the test fail
+	// when issued by the setup or by a Then.
+	WithWebhooks(webhooks ...Webhook) Scenario[R, T]
+	// Declares the storage version of multi-version kinds: the objects of any other version
+	// of the same kind are converted to the storage one when written (including the initial
+	// objects), and converted back to the version requested when read, listed or watched.
+	// For example, the initial objects could be set up in an old version, while the reconciler
+	// and the conditions use the new one. The conversion relies on the controller-runtime
+	// Hub and Convertible implementations if available, otherwise on the conversion functions
+	// registered in the scenario scheme (see WithSchemes).
+	WithStorageVersion(objs ...client.Object) Scenario[R, T]
+	// Makes the nth call (starting from 1) issued by the reconciler with the specified verb
+	// on the resource kind gvk fail with the given error, without being executed. If nth is
+	// zero, all the matching calls will fail, while an empty verb or gvk matches any verb or kind.
+	// Presets like ConflictFault or TimeoutFault could be used to return the same errors
+	// of the API server for the resource involved.
+	// The calls made by the steps, for example in a Then, or by the watch handlers, like the
+	// map functions, are never affected, even when using the reconciler client.
+	FailOn(verb Verb, gvk schema.GroupVersionKind, nth int, err error) Scenario[R, T]
 	// This method can be used to feed a number of initial objects
 	// in the current scenario.
 	Setup(...ObjectsBuilder) _reconcileNextRequest[T]
@@ -44,11 +148,33 @@ type _reconcileNextRequest[T client.Object] interface {
 	_reconcileLoop[T]
 	// NextRequest specifies which resource will be triggered for the next
 	// reconcile invocations. The resource must be already present in the
-	// current cache.
+	// current cache. When watches are declared (see For), the request is
+	// just added to the queue, and it also becomes the one used for the
+	// next conditions evaluation. The test fails if the request refers to an
+	// object of a different kind than T, when no T could exist for the request,
+	// for example a namespaced request for a cluster scoped kind (see NextRequestFor).
+	// A missing T is instead always accepted, even if an object of another kind has
+	// the same name.
 	NextRequest(name string, namespace ...string) _reconcileLoop[T]
 	// Similar to NextRequest, but it allows to create a new client object.
 	// Useful when the object is not already present in the cache.
 	NextRequestObject(func() client.Object) _reconcileLoop[T]
+	// ReconcileAll makes the following steps reconcile the requests of all the scenario
+	// controllers (see WithController), until a single controller is selected again (see
+	// ReconcileWith). A deterministic scheduler picks the next controller, in a round
+	// robin fashion, among the ones having a request ready to be processed: so, for
+	// example, ReconcileUntilIdle will wait for all the controllers to settle.
+	ReconcileAll() _reconcileNextRequest[T]
+	// AdvanceTime moves forward the scenario clock by the specified duration.
+	// A request requeued by the reconciler with a RequeueAfter result will be
+	// reconciled again only when its deadline will be elapsed on the scenario
+	// clock. The test fails if the duration is not positive.
+	AdvanceTime(d time.Duration) _reconcileNextRequest[T]
+	// DeleteObject deletes the object of type T, which becomes the current request (see
+	// NextRequest). Like in a real cluster, an object with finalizers is not removed, but
+	// its deletion timestamp is set: it will be removed only once the reconciler clears all
+	// its finalizers. No new finalizers can be added in the meanwhile.
+	DeleteObject(name string, namespace ...string) _reconcileNextRequest[T]
 }
 
 type _reconcileLoop[T client.Object] interface {
@@ -56,23 +182,96 @@ type _reconcileLoop[T client.Object] interface {
 	// This method will keep invoking the reconciler until the predicate will be
 	// satisfied, or will make the test fail if the number of unsuccesfull reconciles
 	// will be equal or greater than the configured max reconciles value (default: 20).
+	// Errors returned by the reconciler do not interrupt the loop, but the last one will
+	// be reported in case of failure. A TerminalError will instead make the test fail
+	// immediately (see ExpectTerminalError).
 	// An additional label can be optionally specified, to make it easier identifying
 	// the step in case of failure.
 	// The predicate will be provided with a client instace, and the current reconcile
 	// object (if it was matching the configured object type T).
+	// If the reconciler requested to be requeued after a given time, it will not be
+	// invoked again until the scenario clock will reach the deadline (see AdvanceTime):
+	// in such case the test will fail if the predicate is not already satisfied.
 	ReconcileUntil(f func(client client.Client, obj T) bool, labels ...string) _reconcileAction[T]
+	// Same as ReconcileUntil, but the condition is satisfied when no error is returned.
+	// The last error returned will be reported in case of failure, to explain why the
+	// condition was not satisfied.
+	ReconcileUntilE(f func(client client.Client, obj T) error, labels ...string) _reconcileAction[T]
+	// Same as ReconcileUntilE, but using a matcher on the current reconcile object
+	// (see AllOf, HasLabel, HasCondition and the other matchers).
+	ReconcileUntilMatch(matcher Matcher, labels ...string) _reconcileAction[T]
+	// Same as ReconcileUntil, but the condition is satisfied when the finalizer was added to
+	// the current reconcile object.
+	ExpectFinalizerAdded(finalizer string, labels ...string) _reconcileAction[T]
+	// Same as ReconcileUntil, but the condition is satisfied when the current reconcile object
+	// was removed from the scenario cluster, for example after a DeleteObject once the reconciler
+	// cleared its finalizers. The finalizers still pending will be reported in case of failure.
+	ExpectDeleted(labels ...string) _reconcileAction[T]
+	// Same as ReconcileUntil, but the condition is satisfied when the status of the current
+	// reconcile object reports as observed generation (status.observedGeneration) the current
+	// one. Like in a real cluster, the generation is incremented by every change not limited
+	// to the object metadata or status.
+	ExpectObservedGenerationCurrent(labels ...string) _reconcileAction[T]
+	// ReconcileUntilIdle keeps invoking the reconciler until there are no more requests
+	// ready to be processed, ie when the reconciler stops requesting work. The requests
+	// scheduled via RequeueAfter are not considered until their deadline will be elapsed on
+	// the scenario clock (see AdvanceTime). The test will fail if the max reconciles value is
+	// reached, for example when the reconciler keeps returning an error or is in a hot loop.
+	// When no watches are declared (see For), only the current request is considered.
+	ReconcileUntilIdle(labels ...string) _reconcileAction[T]
+	// ExpectResult invokes the reconciler once, and makes the test fail if the returned
+	// result is different from the expected one or if an error was returned.
+	ExpectResult(result reconcile.Result, labels ...string) _reconcileAction[T]
+	// ExpectError invokes the reconciler once, and makes the test fail if no error was
+	// returned or if the error does not satisfy the matcher (a nil matcher will accept
+	// any error). Functions like k8serr.IsConflict could be directly used as matchers.
+	ExpectError(matcher func(err error) bool, labels ...string) _reconcileAction[T]
+	// ExpectTerminalError keeps invoking the reconciler until a TerminalError will be
+	// returned, and makes the test fail if the error does not satisfy the matcher (a nil
+	// matcher will accept any terminal error) or if the max reconciles value is reached.
+	// Like in controller-runtime, a request is not requeued after a TerminalError.
+	ExpectTerminalError(matcher func(err error) bool, labels ...string) _reconcileAction[T]
 }
 
 type _reconcileAction[T client.Object] interface {
 	_reconcileNextRequest[T]
+	// Always makes the test fail if the predicate will not be satisfied after any of the
+	// reconciles executed during the previous step. An additional label can be optionally
+	// specified to identify the condition.
+	Always(f func(client client.Client, obj T) bool, labels ...string) _reconcileAction[T]
+	// Never makes the test fail if the predicate will be satisfied after any of the
+	// reconciles executed during the previous step.
+	Never(f func(client client.Client, obj T) bool, labels ...string) _reconcileAction[T]
+	// ExpectCalls makes the test fail if the number of calls issued by the reconciler
+	// during the previous step, with the specified verb on the resource kind gvk, is
+	// different from the expected one. An empty verb or gvk matches any verb or kind.
+	ExpectCalls(verb Verb, gvk schema.GroupVersionKind, n int) _reconcileAction[T]
+	// ExpectWrites makes the test fail if the number of write calls issued by the
+	// reconciler during the previous step is different from the expected one.
+	ExpectWrites(n int) _reconcileAction[T]
+	// ExpectNoWrites makes the test fail if the reconciler issued any write call
+	// during the previous step. Useful to detect unwanted updates.
+	ExpectNoWrites() _reconcileAction[T]
+	// VerifyCalls allows to inspect all the calls issued by the reconciler during
+	// the previous step. The test fails if an error is returned.
+	VerifyCalls(check func(calls []Call) error) _reconcileAction[T]
 	// The Then allows to specify an handler usually invoked after a successfull ReconcileUntil.
-	// The handler could be used to modify the current environment.
+	// The handler could be used to modify the current environment. A change on the object
+	// of the current request will trigger immediately a new reconcile.
 	Then(f func(client client.Client, obj T), labels ...string) _reconcileNextRequest[T]
 }
 
 type _reconcileLeaf[T client.Object] interface {
 	Testable
 	Case() Testable
+	// FaultCampaign runs the scenario once to record all the calls issued by the
+	// reconciler, and then runs it again once for every recorded call, making only
+	// that call fail with the given error (TimeoutFault if nil). The test fails if
+	// any of the runs does not satisfy all the steps, and the calls causing the
+	// failures are reported.
+	// Steps like ExpectResult or ExpectError verify a single reconcile outcome, so
+	// they are usually not suitable for a fault campaign.
+	FaultCampaign(err error) Testable
 }
 
 // For test environment integration.
@@ -8,36 +8,104 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
 	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/types"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	testingclock "k8s.io/utils/clock/testing"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/andfasano/epistatest/pkg/conditions"
 )
 
 const (
 	defaultMaxReconciles = 20
 )
 
+// errNotSatisfied is returned by a boolean condition not satisfied.
+var errNotSatisfied = errors.New("condition not satisfied")
+
 type scenario[R reconcile.Reconciler, T client.Object] struct {
 	maxReconciles int // max number of reconcile steps
 
-	schemes []func(*runtime.Scheme) error // list of schemes to be applied
-	setup   func() []client.Object        // setup handler
-	steps   []reconcileStep[T]            // steps to be executed
+	schemes         []func(*runtime.Scheme) error        // list of schemes to be applied
+	setup           func() []client.Object               // setup handler
+	steps           []reconcileStep[T]                   // steps to be executed
+	factory         func(deps Deps) R                    // optional reconciler factory
+	dependencies    []any                                // user registered dependencies
+	forObject       client.Object                        // main resource type watched
+	watchSources    []watchSource                        // declared watches
+	setupWithMgr    func(r R, mgr manager.Manager) error // reconciler setup, for capturing its watches
+	faults          []fault                              // faults injected in the reconciler client
+	campaign        error                                // fault injected by a fault campaign
+	idempotency     bool                                 // checks idempotency after each ReconcileUntil
+	invariants      []invariant[T]                       // conditions verified after every reconcile
+	dump            bool                                 // dumps the stored objects on failure
+	dumpDir         string                               // where the stored objects will be dumped
+	history         *conditions.History                  // conditions transitions observed after every reconcile
+	controllerSpecs []controllerSpec                     // additional controllers
+	simulators      []Simulator                          // simulated cluster behaviors
+	gc              bool                                 // enables the garbage collector emulation
+	crdPaths        []string                             // CRD manifests to be loaded
+	webhooks        []Webhook                            // admission webhooks invoked on every write
+	storageVersions []client.Object                      // storage versions of the multi-version kinds
+
+	reconciler reconcile.Reconciler    // user reconciler
+	client     client.WithWatch        // client to be used with the reconciler
+	clock      *testingclock.FakeClock // scenario clock
+	queue      *requestQueue           // requests scheduled by the reconciler
+	deps       Deps                    // dependencies available for the reconciler
+	watchers   []watcher               // watches receiving the client events
+	setupObjs  []client.Object         // initial objects
+	current    reconcile.Request       // latest request reconciled or explicitly set
+	injector   *faultInjector          // faults injector and calls recorder
+	failCall   int                     // call failed in the current fault campaign run
+	reconciles int                     // number of reconciles executed
+	checkCall  int                     // first call issued by the idempotency check in the current step
+	unsettled  error                   // simulated cluster not settled after the latest reconcile
+	changes    *stepChanges            // objects changed during the current step
 
-	reconciler reconcile.Reconciler // user reconciler
-	client     client.WithWatch     // client to be used with the reconciler
+	controllers    []*controller // all the controllers, the main one first
+	active         *controller   // controller currently reconciled
+	global         bool          // all the controllers are reconciled, by the scheduler
+	nextController int           // next controller considered by the scheduler
+	turn           int           // requests left to the active controller in its scheduler turn
+	stop           func()        // stops the controllers started by the setups
+	simulations    []*controller // configured simulators
+	collector      *garbageCollector
+	admission      admissionChain    // schemas and webhooks admitting every write
+	converter      *versionConverter // conversion of the multi-version kinds, if any
 }
 
 type reconcileStep[T runtime.Object] struct {
 	label string
 
-	waitFor func(client client.Client, obj T) bool
-	action  func(client client.Client, obj T)
-	nextReq func() (types.NamespacedName, error)
+	waitFor    func(client client.Client, obj T) error
+	action     func(client client.Client, obj T) error
+	expect     func(result reconcile.Result, err error) error
+	terminal   func(err error) bool
+	idle       bool
+	checks     []callsCheck
+	always     []invariant[T]
+	nextReq    func() (types.NamespacedName, error)
+	controller string // controller selected for the next steps
+	clockStep  bool   // moves forward the scenario clock by advance
+	advance    time.Duration
 }
 
 func newScenario[R reconcile.Reconciler, T client.Object]() *scenario[R, T] {
@@ -56,6 +124,113 @@ func (s *scenario[R, T]) WithMaxReconciles(n int) Scenario[R, T] {
 	return s
 }
 
+func (s *scenario[R, T]) WithReconcilerFactory(factory func(deps Deps) R) Scenario[R, T] {
+	s.factory = factory
+	return s
+}
+
+func (s *scenario[R, T]) WithDependencies(values ...any) Scenario[R, T] {
+	s.dependencies = append(s.dependencies, values...)
+	return s
+}
+
+func (s *scenario[R, T]) For(obj client.Object, predicates ...predicate.Predicate) Scenario[R, T] {
+	s.forObject = obj
+	return s.Watches(obj, &handler.EnqueueRequestForObject{}, predicates...)
+}
+
+func (s *scenario[R, T]) Owns(obj client.Object, predicates ...predicate.Predicate) Scenario[R, T] {
+	s.watchSources = append(s.watchSources, watchSource{
+		object: obj,
+		handler: func(scheme *runtime.Scheme, mapper meta.RESTMapper) handler.EventHandler {
+			var owner client.Object = s.newObjectInstance()
+			if s.forObject != nil {
+				owner = s.forObject
+			}
+			return handler.EnqueueRequestForOwner(scheme, mapper, owner, handler.OnlyControllerOwner())
+		},
+		predicates: predicates,
+	})
+	return s
+}
+
+func (s *scenario[R, T]) Watches(obj client.Object, eventHandler handler.EventHandler, predicates ...predicate.Predicate) Scenario[R, T] {
+	s.watchSources = append(s.watchSources, watchSource{
+		object: obj,
+		handler: func(*runtime.Scheme, meta.RESTMapper) handler.EventHandler {
+			return eventHandler
+		},
+		predicates: predicates,
+	})
+	return s
+}
+
+func (s *scenario[R, T]) FromSetupWithManager(setup func(r R, mgr manager.Manager) error) Scenario[R, T] {
+	s.setupWithMgr = setup
+	return s
+}
+
+func (s *scenario[R, T]) WithIdempotencyCheck() Scenario[R, T] {
+	s.idempotency = true
+	return s
+}
+
+func (s *scenario[R, T]) WithInvariant(name string, check func(client client.Client) error) Scenario[R, T] {
+	s.invariants = append(s.invariants, invariant[T]{
+		name: name,
+		check: func(c client.Client, _ T) error {
+			return check(c)
+		},
+	})
+	return s
+}
+
+func (s *scenario[R, T]) WithConditionHistory(history *conditions.History) Scenario[R, T] {
+	s.history = history
+	return s
+}
+
+func (s *scenario[R, T]) WithSimulators(simulators ...Simulator) Scenario[R, T] {
+	s.simulators = append(s.simulators, simulators...)
+	return s
+}
+
+func (s *scenario[R, T]) WithGarbageCollector() Scenario[R, T] {
+	s.gc = true
+	return s
+}
+
+func (s *scenario[R, T]) WithCRDs(paths ...string) Scenario[R, T] {
+	s.crdPaths = append(s.crdPaths, paths...)
+	return s
+}
+
+func (s *scenario[R, T]) WithWebhooks(webhooks ...Webhook) Scenario[R, T] {
+	s.webhooks = append(s.webhooks, webhooks...)
+	return s
+}
+
+func (s *scenario[R, T]) WithStorageVersion(objs ...client.Object) Scenario[R, T] {
+	s.storageVersions = append(s.storageVersions, objs...)
+	return s
+}
+
+func (s *scenario[R, T]) WithStateDump(dir string) Scenario[R, T] {
+	s.dump = true
+	s.dumpDir = dir
+	return s
+}
+
+func (s *scenario[R, T]) FailOn(verb Verb, gvk schema.GroupVersionKind, nth int, err error) Scenario[R, T] {
+	s.faults = append(s.faults, fault{
+		verb: verb,
+		gvk:  gvk,
+		nth:  nth,
+		err:  err,
+	})
+	return s
+}
+
 func (s *scenario[R, T]) SetupObjects(setup func() []client.Object) _reconcileNextRequest[T] {
 	s.setup = setup
 	return s
@@ -72,6 +247,13 @@ func (s *scenario[R, T]) Setup(builders ...ObjectsBuilder) _reconcileNextRequest
 }
 
 func (s *scenario[R, T]) NextRequest(name string, namespace ...string) _reconcileLoop[T] {
+	s.nextRequestFor(func() client.Object { return s.newObjectInstance() }, name, namespace...)
+	return s
+}
+
+// nextRequestFor adds a step setting the next request, which must refer an object
+// of the same type of the one returned by newObj (see verifyRequestKind).
+func (s *scenario[R, T]) nextRequestFor(newObj func() client.Object, name string, namespace ...string) {
 	nextReq := func() (types.NamespacedName, error) {
 		r := types.NamespacedName{
 			Name: name,
@@ -79,13 +261,12 @@ func (s *scenario[R, T]) NextRequest(name string, namespace ...string) _reconcil
 		if len(namespace) > 0 {
 			r.Namespace = namespace[0]
 		}
-		return r, nil
+		return r, s.verifyRequestKind(r, newObj())
 	}
 
 	s.steps = append(s.steps, reconcileStep[T]{
 		nextReq: nextReq,
 	})
-	return s
 }
 
 func (s *scenario[R, T]) NextRequestObject(nextReqObj func() client.Object) _reconcileLoop[T] {
@@ -115,7 +296,30 @@ func (s *scenario[R, T]) NextRequestObject(nextReqObj func() client.Object) _rec
 	return s
 }
 
+func (s *scenario[R, T]) ReconcileAll() _reconcileNextRequest[T] {
+	s.selectController(allControllers)
+	return s
+}
+
+func (s *scenario[R, T]) AdvanceTime(d time.Duration) _reconcileNextRequest[T] {
+	s.steps = append(s.steps, reconcileStep[T]{
+		label:     fmt.Sprintf("advance time by %s", d),
+		clockStep: true,
+		advance:   d,
+	})
+	return s
+}
+
 func (s *scenario[R, T]) ReconcileUntil(waitFor func(client client.Client, obj T) bool, labels ...string) _reconcileAction[T] {
+	return s.ReconcileUntilE(func(client client.Client, obj T) error {
+		if !waitFor(client, obj) {
+			return errNotSatisfied
+		}
+		return nil
+	}, labels...)
+}
+
+func (s *scenario[R, T]) ReconcileUntilE(waitFor func(client client.Client, obj T) error, labels ...string) _reconcileAction[T] {
 	s.steps = append(s.steps, reconcileStep[T]{
 		waitFor: waitFor,
 		label:   strings.Join(labels, ", "),
@@ -123,7 +327,129 @@ func (s *scenario[R, T]) ReconcileUntil(waitFor func(client client.Client, obj T
 	return s
 }
 
+func (s *scenario[R, T]) ReconcileUntilMatch(matcher Matcher, labels ...string) _reconcileAction[T] {
+	return s.ReconcileUntilE(func(_ client.Client, obj T) error {
+		o, ok := any(obj).(client.Object)
+		if !ok {
+			return fmt.Errorf("cannot match %T, not a client.Object", obj)
+		}
+		return matcher(o)
+	}, labels...)
+}
+
+func (s *scenario[R, T]) ExpectResult(expected reconcile.Result, labels ...string) _reconcileAction[T] {
+	s.steps = append(s.steps, reconcileStep[T]{
+		expect: func(result reconcile.Result, err error) error {
+			if err != nil {
+				return fmt.Errorf("expected result %+v but received error: %w", expected, err)
+			}
+			if result != expected {
+				return fmt.Errorf("expected result %+v but received %+v", expected, result)
+			}
+			return nil
+		},
+		label: strings.Join(labels, ", "),
+	})
+	return s
+}
+
+func (s *scenario[R, T]) ExpectError(matcher func(err error) bool, labels ...string) _reconcileAction[T] {
+	s.steps = append(s.steps, reconcileStep[T]{
+		expect: func(result reconcile.Result, err error) error {
+			if err == nil {
+				return fmt.Errorf("expected an error but none received")
+			}
+			if matcher != nil && !matcher(err) {
+				return fmt.Errorf("unexpected error: %w", err)
+			}
+			return nil
+		},
+		label: strings.Join(labels, ", "),
+	})
+	return s
+}
+
+func (s *scenario[R, T]) ExpectTerminalError(matcher func(err error) bool, labels ...string) _reconcileAction[T] {
+	if matcher == nil {
+		matcher = func(error) bool { return true }
+	}
+	s.steps = append(s.steps, reconcileStep[T]{
+		terminal: matcher,
+		label:    strings.Join(labels, ", "),
+	})
+	return s
+}
+
+func (s *scenario[R, T]) ReconcileUntilIdle(labels ...string) _reconcileAction[T] {
+	s.steps = append(s.steps, reconcileStep[T]{
+		idle:  true,
+		label: strings.Join(labels, ", "),
+	})
+	return s
+}
+
+func (s *scenario[R, T]) ExpectCalls(verb Verb, gvk schema.GroupVersionKind, n int) _reconcileAction[T] {
+	return s.addCallsCheck(countCalls(verb, gvk, n))
+}
+
+func (s *scenario[R, T]) ExpectWrites(n int) _reconcileAction[T] {
+	return s.addCallsCheck(countWrites(n))
+}
+
+func (s *scenario[R, T]) ExpectNoWrites() _reconcileAction[T] {
+	return s.addCallsCheck(countWrites(0))
+}
+
+func (s *scenario[R, T]) VerifyCalls(check func(calls []Call) error) _reconcileAction[T] {
+	return s.addCallsCheck(check)
+}
+
+func (s *scenario[R, T]) Always(f func(client client.Client, obj T) bool, labels ...string) _reconcileAction[T] {
+	return s.addInvariant(labels, "always", func(c client.Client, obj T) error {
+		if !f(c, obj) {
+			return errNotSatisfied
+		}
+		return nil
+	})
+}
+
+func (s *scenario[R, T]) Never(f func(client client.Client, obj T) bool, labels ...string) _reconcileAction[T] {
+	return s.addInvariant(labels, "never", func(c client.Client, obj T) error {
+		if f(c, obj) {
+			return fmt.Errorf("condition satisfied")
+		}
+		return nil
+	})
+}
+
+func (s *scenario[R, T]) addInvariant(labels []string, defaultName string, check func(c client.Client, obj T) error) _reconcileAction[T] {
+	lastStep := &s.steps[len(s.steps)-1]
+	name := strings.Join(labels, ", ")
+	if name == "" {
+		name = fmt.Sprintf("%s #%d", defaultName, len(lastStep.always))
+	}
+	lastStep.always = append(lastStep.always, invariant[T]{
+		name:  name,
+		check: check,
+	})
+	return s
+}
+
+func (s *scenario[R, T]) addCallsCheck(check callsCheck) _reconcileAction[T] {
+	lastStep := &s.steps[len(s.steps)-1]
+	lastStep.checks = append(lastStep.checks, check)
+	return s
+}
+
 func (s *scenario[R, T]) Then(action func(client client.Client, obj T), labels ...string) _reconcileNextRequest[T] {
+	return s.then(func(c client.Client, obj T) error {
+		action(c, obj)
+		return nil
+	}, labels...)
+}
+
+// then sets the action of the latest step, which fails if an error is returned.
+func (s *scenario[R, T]) then(action func(client client.Client, obj T) error, labels ...string) _reconcileNextRequest[T] {
 	lastStep := &s.steps[len(s.steps)-1]
 	lastStep.action = action
 	lastStep.label = strings.Join(labels, ", ")
@@ -142,28 +468,60 @@ func (s *scenario[R, T]) makeScheme() (*runtime.Scheme, error) {
 			return nil, err
 		}
 	}
+	for _, sim := range s.simulators {
+		if err := sim.AddToScheme(scheme); err != nil {
+			return nil, err
+		}
+	}
 
 	return scheme, nil
 }
 
 func (s *scenario[R, T]) newObjectInstance() T {
-	return reflect.New(reflect.TypeOf(*new(T)).Elem()).Interface().(T)
+	return newObject[T]()
 }
 
 func (s *scenario[R, T]) Test(t *testing.T) {
 	t.Helper()
+	if s.dump && s.dumpDir == "" {
+		s.dumpDir = t.TempDir()
+	}
 	if err := s.test(); err != nil {
+		var failure *stepFailure
+		if errors.As(err, &failure) {
+			t.Fatal(err.Error() + failure.report)
+		}
 		t.Fatal(err)
 	}
 }
 
+func (s *scenario[R, T]) FaultCampaign(err error) Testable {
+	if err == nil {
+		err = TimeoutFault()
+	}
+	s.campaign = err
+	return s
+}
+
 func (s *scenario[R, T]) test() error {
+	defer s.stopControllers()
+	if s.campaign != nil {
+		return s.runFaultCampaign()
+	}
 	if err := s.setupEnv(); err != nil {
 		return err
 	}
 	return s.run()
 }
 
+// stopControllers stops the controllers started in the latest setup, if any.
+func (s *scenario[R, T]) stopControllers() {
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+}
+
 func (s *scenario[R, T]) setupEnv() error {
 	if len(s.steps) == 0 {
 		return fmt.Errorf("no steps found")
@@ -173,87 +531,623 @@ func (s *scenario[R, T]) setupEnv() error {
 	if err != nil {
 		return err
 	}
+	s.stopControllers()
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+
+	s.clock = testingclock.NewFakeClock(time.Now())
+	s.queue = newRequestQueue(s.clock)
+	s.current = reconcile.Request{}
+	s.reconciles = 0
+	s.unsettled = nil
+	s.changes = newStepChanges()
+	if s.history != nil {
+		s.history.Reset()
+	}
+
+	s.admission = admissionChain{}
+	if len(s.crdPaths) > 0 {
+		crds, err := loadCRDs(s.crdPaths)
+		if err != nil {
+			return err
+		}
+		if s.admission.crds, err = newCRDSchemas(crds); err != nil {
+			return err
+		}
+	}
+	if s.admission.webhooks, err = newAdmissionWebhooks(s.webhooks, scheme); err != nil {
+		return err
+	}
+
+	s.converter = nil
+	if len(s.storageVersions) > 0 {
+		if s.converter, err = newVersionConverter(s.storageVersions, scheme); err != nil {
+			return err
+		}
+	}
+
+	mapper := testrestmapper.TestOnlyStaticRESTMapper(scheme)
+	s.setupObjs = s.setup()
+	for _, obj := range s.setupObjs {
+		initObjectMetadata(obj, s.clock)
+		if err := s.admission.admit(context.Background(), obj, nil, false, scheme); err != nil {
+			return err
+		}
+	}
+	storedObjs := s.setupObjs
+	if s.converter != nil {
+		storedObjs = make([]client.Object, 0, len(s.setupObjs))
+		for _, obj := range s.setupObjs {
+			stored, err := s.converter.toStorage(obj)
+			if err != nil {
+				return err
+			}
+			storedObjs = append(storedObjs, stored)
+		}
+	}
+	tracker := clienttesting.NewObjectTracker(scheme, serializer.NewCodecFactory(scheme).UniversalDecoder())
+	statusObjs := append(s.admission.crds.statusSubresources(scheme), storedObjs...)
+	indexer := &fieldIndexer{scheme: scheme, builder: func() *fake.ClientBuilder {
+		return fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithRESTMapper(mapper).
+			WithObjectTracker(tracker).
+			WithStatusSubresource(statusObjs...)
+	}}
+	var baseClient client.WithWatch = withFieldIndexes(indexer.builder().WithObjects(storedObjs...).Build(), indexer)
+	if s.converter != nil {
+		baseClient = withConversion(baseClient, s.converter)
+	}
+	if s.gc {
+		baseClient = withDeletePropagation(baseClient)
+	}
+	baseClient = withObjectMetadata(baseClient, s.clock)
+	if s.admission.enabled() {
+		baseClient = withAdmission(baseClient, s.admission)
+	}
+	s.client = withChangeNotifier(withDeletionLifecycle(baseClient), s.dispatch)
+	s.collector = nil
+	if s.gc {
+		s.collector = &garbageCollector{client: s.client, converter: s.converter}
+	}
+
+	// Faults are injected only in the calls made by the reconciler.
+	faults := s.faults
+	if s.failCall > 0 {
+		faults = append(append([]fault{}, faults...), fault{nth: s.failCall, err: s.campaign})
+	}
+	s.injector = newFaultInjector(faults, scheme, mapper)
+	recorder, found := Dependency[record.EventRecorder](Deps{values: s.dependencies})
+	if !found {
+		recorder = &record.FakeRecorder{}
+	}
 
-	objs := s.setup()
-	s.client = fake.NewClientBuilder().
-		WithScheme(scheme).
-		WithObjects(objs...).
-		WithStatusSubresource(objs...).
-		Build()
+	s.deps = Deps{
+		Client:   withFaults(s.client, s.injector),
+		Scheme:   scheme,
+		Recorder: recorder,
+		Logger:   logr.New(log.NullLogSink{}),
+		Clock:    s.clock,
+		values:   s.dependencies,
+		indexer:  indexer,
+	}
 
-	reconciler, err := s.createReconcilerWithClient()
+	reconciler, err := s.createReconciler()
 	if err != nil {
 		return err
 	}
 	s.reconciler = reconciler
 
-	return nil
+	sources := s.watchSources
+	workers := 1
+	if s.setupWithMgr != nil {
+		var mgrSources []watchSource
+		mgrSources, workers, err = captureWatches(ctx, s.deps, mapper, s.queue, func(mgr manager.Manager) error {
+			return s.setupWithMgr(reconciler, mgr)
+		})
+		if err != nil {
+			return err
+		}
+		sources = append(append([]watchSource{}, sources...), mgrSources...)
+	}
+	s.watchers, err = newWatchers(sources, scheme, mapper)
+	if err != nil {
+		return err
+	}
+
+	if err := s.setupControllers(ctx, mapper, workers); err != nil {
+		return err
+	}
+	return s.setupSimulators(ctx, mapper)
 }
 
 func (s *scenario[R, T]) reconcileStepError(step reconcileStep[T], err error) error {
 	return fmt.Errorf("step `%s` failure: %w", step.label, err)
 }
 
+func (s *scenario[R, T]) stepLabel(idx int, step reconcileStep[T]) string {
+	if step.label != "" {
+		return step.label
+	}
+	return fmt.Sprintf("waiting condition #%s", strconv.Itoa(idx))
+}
+
 func (s *scenario[R, T]) run() error {
-	var nextReq types.NamespacedName
-	var err error
+	// Like an informer initial list, all the setup objects are notified
+	// to the watchers.
+	for _, obj := range s.setupObjs {
+		if stored := snapshot(context.Background(), s.client, obj); stored != nil {
+			s.dispatch(objectChange{New: stored})
+		}
+	}
+	if !s.watchMode() {
+		// The initial request is immediately available.
+		s.queue.Add(s.current)
+	}
+	if err := s.stepSimulators(); err != nil {
+		return err
+	}
 
 	for idx, step := range s.steps {
+		// Select the controller for the next steps.
+		if step.controller != "" {
+			if err := s.useController(step.controller); err != nil {
+				return s.reconcileStepError(step, err)
+			}
+			continue
+		}
+
 		// Prepare the object for the next reconcile invokation.
 		if step.nextReq != nil {
-			nextReq, err = step.nextReq()
+			nextReq, err := step.nextReq()
 			if err != nil {
 				return s.reconcileStepError(step, err)
 			}
+			s.current = reconcile.Request{NamespacedName: nextReq}
+			s.queue.Add(s.current)
+			if err := s.stepSimulators(); err != nil {
+				return s.reconcileStepError(step, err)
+			}
 			continue
 		}
 
-		// Keep reconciling until either the waitFor condition will be satisfied or max reconcile
-		// steps will be reached.
-		// In addition, like for the regular controller-runtime case, a TerminalError will stop
-		// the reconciliation.
-		reconcileCounter := 0
-		for ; reconcileCounter < s.maxReconciles; reconcileCounter++ {
-			_, err = s.reconciler.Reconcile(context.Background(), reconcile.Request{NamespacedName: nextReq})
-			if errors.Is(err, reconcile.TerminalError(nil)) {
-				return nil
+		// Move forward the scenario clock.
+		if step.clockStep {
+			if step.advance <= 0 {
+				return s.reconcileStepError(step, fmt.Errorf("invalid duration %s, the scenario clock can only move forward", step.advance))
 			}
-
-			latestUpdatedObj := s.newObjectInstance()
-			err = s.client.Get(context.Background(), nextReq, latestUpdatedObj)
-			if err != nil && !k8serr.IsNotFound(err) {
+			s.clock.Step(step.advance)
+			if err := s.stepSimulators(); err != nil {
 				return s.reconcileStepError(step, err)
 			}
+			continue
+		}
+
+		s.injector.step = s.stepLabel(idx, step)
+		firstCall := len(s.injector.recorded)
+		s.checkCall = -1
+		s.changes = newStepChanges()
+
+		var err error
+		switch {
+		case step.expect != nil:
+			err = s.runExpect(idx, step)
+		case step.terminal != nil:
+			err = s.runExpectTerminalError(idx, step)
+		case step.idle:
+			err = s.runReconcileUntilIdle(idx, step)
+		default:
+			err = s.runReconcileUntil(idx, step)
+		}
+		if err != nil {
+			return s.stepFailure(err, s.injector.recorded[firstCall:])
+		}
+
+		// Verify the calls issued by the reconciler during the step.
+		calls := s.injector.recorded[firstCall:]
+		if s.checkCall >= 0 {
+			calls = s.injector.recorded[firstCall:s.checkCall]
+		}
+		for _, check := range step.checks {
+			if err := check(calls); err != nil {
+				return s.stepFailure(fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err), calls)
+			}
+		}
+	}
+
+	return nil
+}
+
+// watchMode returns true when the reconcile requests are driven by the events
+// received by the declared watches, instead of being explicitly set by the steps.
+func (s *scenario[R, T]) watchMode() bool {
+	return len(s.watchers) > 0
+}
 
-			if step.waitFor(s.client, latestUpdatedObj) {
-				if step.action != nil {
-					step.action(s.client, latestUpdatedObj)
+// dispatch notifies the change to all the declared watches, of every controller.
+// The calls issued by the watch handlers, like the map functions, are not
+// considered reconciler calls, even when using the reconciler client.
+func (s *scenario[R, T]) dispatch(change objectChange) {
+	defer s.injector.suspend()()
+	gvk, err := apiutil.GVKForObject(change.Object(), s.client.Scheme())
+	if err != nil {
+		return
+	}
+	s.changes.track(gvk, change)
+	for _, c := range append(append([]*controller{}, s.controllers...), s.simulations...) {
+		for _, w := range c.watchers {
+			// The watches on other versions of a converted kind receive the converted objects.
+			if s.converter != nil && w.gvk != gvk && w.gvk.GroupKind() == gvk.GroupKind() {
+				if converted, err := s.converter.convertChange(change, w.gvk); err == nil {
+					w.notify(w.gvk, converted, c.queue)
 				}
-				reconcileCounter = 0
-				break
+				continue
 			}
+			w.notify(gvk, change, c.queue)
+		}
+	}
+}
+
+// dequeue returns the next request to be reconciled.
+// In watch mode, it is the next ready request from the queue. Otherwise it is
+// always the current request, unless it is waiting for a RequeueAfter deadline not
+// yet elapsed on the scenario clock.
+// If no request is available, an error describing the pending ones is returned.
+func (s *scenario[R, T]) dequeue() (reconcile.Request, error) {
+	if s.global {
+		if err := s.schedule(); err != nil {
+			return reconcile.Request{}, err
+		}
+		req, _ := s.queue.Get()
+		return req, nil
+	}
+	if s.watchMode() {
+		if s.queue.Len() == 0 {
+			return reconcile.Request{}, s.queue.pending()
+		}
+		req, _ := s.queue.Get()
+		return req, nil
+	}
+
+	if delay, waiting := s.queue.delay(s.current); waiting {
+		return reconcile.Request{}, fmt.Errorf("next reconcile scheduled in %s", delay)
+	}
+	s.queue.take(s.current)
+	return s.current, nil
+}
+
+// ready returns true if there is at least one request ready to be reconciled.
+// When not in watch mode, only the current request is considered.
+func (s *scenario[R, T]) ready() bool {
+	if s.global {
+		for _, c := range s.controllers {
+			if c.queue.Len() > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	if s.watchMode() {
+		return s.queue.Len() > 0
+	}
+	return s.queue.ready(s.current)
+}
+
+// runReconcileUntil keeps reconciling until either the waitFor condition will be satisfied or
+// max reconcile steps will be reached.
+// The reconciler is not invoked if there are no requests ready to be reconciled: in such
+// case the condition is evaluated only once.
+// A TerminalError will make the test fail, unless explicitly expected (see ExpectTerminalError).
+func (s *scenario[R, T]) runReconcileUntil(idx int, step reconcileStep[T]) error {
+	var lastErr, conditionErr error
+
+	for reconcileCounter := 0; reconcileCounter < s.maxReconciles; reconcileCounter++ {
+		req, pendingErr := s.dequeue()
+		if pendingErr == nil {
+			_, lastErr = s.reconcile(req)
+			if err := s.verifyReconcile(step); err != nil {
+				return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+			}
+			if errors.Is(lastErr, reconcile.TerminalError(nil)) {
+				return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), lastErr)
+			}
+		}
+
+		latestUpdatedObj, err := s.latestObject()
+		if err != nil {
+			return s.reconcileStepError(step, err)
+		}
+
+		conditionErr = step.waitFor(s.client, latestUpdatedObj)
+		if conditionErr == nil {
+			if s.idempotency {
+				if err := s.checkIdempotency(step); err != nil {
+					return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+				}
+			}
+			if step.action != nil {
+				if err := s.runAction(step, latestUpdatedObj); err != nil {
+					return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+				}
+			}
+			return nil
+		}
+
+		if pendingErr != nil {
+			return fmt.Errorf("%s, %w", s.notSatisfied(idx, step, conditionErr), pendingErr)
+		}
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("%s, too many reconcile loops (%d), last reconcile error: %w", s.notSatisfied(idx, step, conditionErr), s.maxReconciles, lastErr)
+	}
+	return fmt.Errorf("%s, too many reconcile loops (%d)", s.notSatisfied(idx, step, conditionErr), s.maxReconciles)
+}
+
+// notSatisfied describes a step whose condition was not satisfied, including
+// the reason when available.
+func (s *scenario[R, T]) notSatisfied(idx int, step reconcileStep[T], conditionErr error) string {
+	if conditionErr == nil || errors.Is(conditionErr, errNotSatisfied) {
+		return fmt.Sprintf("`%s` not satisfied", s.stepLabel(idx, step))
+	}
+	return fmt.Sprintf("`%s` not satisfied (%v)", s.stepLabel(idx, step), conditionErr)
+}
+
+// verifyReconcile verifies the state reached after a reconcile: the simulated cluster
+// must have settled, and all the invariants must hold.
+func (s *scenario[R, T]) verifyReconcile(step reconcileStep[T]) error {
+	if s.unsettled != nil {
+		return s.unsettled
+	}
+	return s.verifyInvariants(step)
+}
+
+// verifyInvariants evaluates both the scenario and the step invariants on the
+// current state.
+func (s *scenario[R, T]) verifyInvariants(step reconcileStep[T]) error {
+	if len(s.invariants) == 0 && len(step.always) == 0 {
+		return nil
+	}
+	obj, err := s.latestObject()
+	if err != nil {
+		return err
+	}
+	for _, inv := range append(append([]invariant[T]{}, s.invariants...), step.always...) {
+		if err := inv.check(s.client, obj); err != nil {
+			return fmt.Errorf("invariant `%s` violated after reconcile #%d (%s): %w%s", inv.name, s.reconciles, s.current, err, formatObject(obj))
 		}
+	}
+	return nil
+}
+
+// checkIdempotency invokes the reconciler once more for the current request, and
+// verifies that no write was issued and that no stored object was changed.
+func (s *scenario[R, T]) checkIdempotency(step reconcileStep[T]) error {
+	ctx := context.Background()
+	before := takeSnapshot(ctx, s.client, s.converter)
+	s.checkCall = len(s.injector.recorded)
+
+	s.queue.take(s.current)
+	_, err := s.reconcile(s.current)
+	if err := s.verifyReconcile(step); err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("idempotency check failure, %w", err)
+	}
+
+	var writes []Call
+	for _, c := range s.injector.recorded[s.checkCall:] {
+		if c.IsWrite() {
+			writes = append(writes, c)
+		}
+	}
+	changes := before.diff(takeSnapshot(ctx, s.client, s.converter))
+	if len(writes) == 0 && changes == "" {
+		return nil
+	}
+	return fmt.Errorf("idempotency check failure, %d writes issued after convergence%s%s", len(writes), formatCalls(writes), changes)
+}
+
+// runReconcileUntilIdle keeps reconciling until there are no more requests ready
+// to be processed, or max reconcile steps will be reached.
+func (s *scenario[R, T]) runReconcileUntilIdle(idx int, step reconcileStep[T]) error {
+	var lastErr error
 
-		if reconcileCounter >= s.maxReconciles {
-			label := step.label
-			if label == "" {
-				label = fmt.Sprintf("waiting condition #%s", strconv.Itoa(idx))
+	for reconcileCounter := 0; s.ready(); reconcileCounter++ {
+		if reconcileCounter == s.maxReconciles {
+			if lastErr != nil {
+				return fmt.Errorf("`%s` not satisfied, controller not idle after %d reconcile loops, last reconcile error: %w", s.stepLabel(idx, step), s.maxReconciles, lastErr)
 			}
-			return fmt.Errorf("`%s` not satisfied, too many reconcile loops (%d)", label, s.maxReconciles)
+			return fmt.Errorf("`%s` not satisfied, controller not idle after %d reconcile loops", s.stepLabel(idx, step), s.maxReconciles)
+		}
+
+		req, err := s.dequeue()
+		if err != nil {
+			return fmt.Errorf("`%s` not satisfied, %w", s.stepLabel(idx, step), err)
+		}
+		_, lastErr = s.reconcile(req)
+		if err := s.verifyReconcile(step); err != nil {
+			return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+		}
+		if errors.Is(lastErr, reconcile.TerminalError(nil)) {
+			return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), lastErr)
 		}
 	}
 
+	latestUpdatedObj, err := s.latestObject()
+	if err != nil {
+		return s.reconcileStepError(step, err)
+	}
+	if step.action != nil {
+		if err := s.runAction(step, latestUpdatedObj); err != nil {
+			return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+		}
+	}
 	return nil
 }
 
-func (s *scenario[R, T]) createReconcilerWithClient() (reconcile.Reconciler, error) {
-	reconciler := new(R)
+// runExpect invokes the reconciler exactly once, and verifies its outcome.
+func (s *scenario[R, T]) runExpect(idx int, step reconcileStep[T]) error {
+	req, err := s.dequeue()
+	if err != nil {
+		return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+	}
 
-	fv := reflect.ValueOf(reconciler).Elem().FieldByName("Client")
-	if !fv.IsValid() {
-		return nil, fmt.Errorf("field 'Client' not found for type %s", reflect.TypeOf(reconciler).Elem().Name())
+	result, reconcileErr := s.reconcile(req)
+	if err := s.verifyReconcile(step); err != nil {
+		return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+	}
+	if err := step.expect(result, reconcileErr); err != nil {
+		return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+	}
+
+	latestUpdatedObj, err := s.latestObject()
+	if err != nil {
+		return s.reconcileStepError(step, err)
 	}
+	if step.action != nil {
+		if err := s.runAction(step, latestUpdatedObj); err != nil {
+			return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+		}
+	}
+	return nil
+}
 
-	fv.Set(reflect.ValueOf(s.client))
-	return *reconciler, nil
+// runExpectTerminalError keeps reconciling until a TerminalError will be returned, or
+// max reconcile steps will be reached.
+func (s *scenario[R, T]) runExpectTerminalError(idx int, step reconcileStep[T]) error {
+	for reconcileCounter := 0; reconcileCounter < s.maxReconciles; reconcileCounter++ {
+		req, err := s.dequeue()
+		if err != nil {
+			return fmt.Errorf("`%s` not satisfied, %w", s.stepLabel(idx, step), err)
+		}
+
+		_, err = s.reconcile(req)
+		if err := s.verifyReconcile(step); err != nil {
+			return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+		}
+		if !errors.Is(err, reconcile.TerminalError(nil)) {
+			continue
+		}
+		if !step.terminal(err) {
+			return fmt.Errorf("`%s` failure, unexpected %w", s.stepLabel(idx, step), err)
+		}
+
+		latestUpdatedObj, err := s.latestObject()
+		if err != nil {
+			return s.reconcileStepError(step, err)
+		}
+		if step.action != nil {
+			if err := s.runAction(step, latestUpdatedObj); err != nil {
+				return fmt.Errorf("`%s` failure, %w", s.stepLabel(idx, step), err)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("`%s` not satisfied, no terminal error after %d reconcile loops", s.stepLabel(idx, step), s.maxReconciles)
+}
+
+// reconcile invokes the reconciler for the given request, and schedules it
+// again according to the returned outcome. The request becomes the current one.
+func (s *scenario[R, T]) reconcile(req reconcile.Request) (reconcile.Result, error) {
+	s.current = req
+	s.reconciles++
+	s.injector.reconcile = s.reconciles
+	s.injector.controller = s.active.name
+
+	ctx := log.IntoContext(context.Background(), s.deps.Logger)
+	s.injector.reconciling = true
+	result, err := s.reconciler.Reconcile(ctx, req)
+	s.injector.reconciling = false
+	s.queue.requeue(req, result, err)
+	s.queue.Done(req)
+	s.unsettled = s.stepSimulators()
+	s.observeConditions()
+	return result, err
+}
+
+// observeConditions records in the conditions history the transitions of the
+// object referenced by the current request. Objects not found, or whose conditions
+// cannot be extracted, are not tracked.
+func (s *scenario[R, T]) observeConditions() {
+	if s.history == nil {
+		return
+	}
+	obj, err := s.latestObject()
+	if err != nil || obj.GetName() == "" {
+		return
+	}
+	_ = s.history.Observe(s.reconciles, obj)
+}
+
+// latestObject fetches the current version of the object referenced by the current request.
+// An empty instance is returned if the object was not found.
+func (s *scenario[R, T]) latestObject() (T, error) {
+	obj := s.newObjectInstance()
+	return obj, s.currentObject(obj)
+}
+
+// runAction invokes the step action. When not in watch mode, if the action modified the
+// object of the current request, the request is immediately made available again for the
+// next reconcile, like it would happen in a real cluster when receiving the related event.
+// An error is returned if any write issued by the action was rejected by the admission.
+func (s *scenario[R, T]) runAction(step reconcileStep[T], obj T) error {
+	before := s.resourceVersion()
+	rejected := &rejectedWrites{}
+	if err := step.action(withRejectedWrites(s.client, rejected), obj); err != nil {
+		return err
+	}
+	settleErr := s.stepSimulators()
+	if !s.watchMode() && s.resourceVersion() != before {
+		s.queue.Add(s.current)
+	}
+	if rejected.err != nil {
+		return rejected.err
+	}
+	return settleErr
+}
+
+func (s *scenario[R, T]) resourceVersion() string {
+	obj := s.newObjectInstance()
+	if err := s.client.Get(context.Background(), s.current.NamespacedName, obj); err != nil {
+		return ""
+	}
+	return obj.GetResourceVersion()
+}
+
+// createReconciler builds the reconciler using the configured factory, if any.
+// Otherwise a new instance of R is created, and its fields are automatically
+// injected with the available dependencies, matching them by type.
+func (s *scenario[R, T]) createReconciler() (R, error) {
+	if s.factory != nil {
+		return s.factory(s.deps), nil
+	}
+	return newReconciler[R](s.deps)
+}
+
+// newReconciler creates a new instance of R, injecting its fields with the
+// available dependencies.
+func newReconciler[R reconcile.Reconciler](deps Deps) (R, error) {
+
+	// Both struct and pointer to struct reconcilers are supported.
+	reconcilerType := reflect.TypeOf((*R)(nil)).Elem()
+	isPtr := reconcilerType.Kind() == reflect.Ptr
+	if isPtr {
+		reconcilerType = reconcilerType.Elem()
+	}
+	if reconcilerType.Kind() != reflect.Struct {
+		return *new(R), fmt.Errorf("unsupported reconciler type %s, a factory is required", reconcilerType.Name())
+	}
+
+	reconciler := reflect.New(reconcilerType)
+	if deps.inject(reconciler.Elem()) == 0 {
+		return *new(R), fmt.Errorf("field 'Client' not found for type %s", reconcilerType.Name())
+	}
+
+	if isPtr {
+		return reconciler.Interface().(R), nil
+	}
+	return reconciler.Elem().Interface().(R), nil
 }
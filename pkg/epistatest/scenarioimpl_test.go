@@ -3,9 +3,13 @@ package epistatest
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	rt "k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -129,6 +133,7 @@ func TestScenario(t *testing.T) {
 				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
 					return obj.Name == ""
 				}),
+			expectedError: "step `` failure: request /node refers to a Node, but a ConfigMap was expected",
 		},
 	}
 	for _, tc := range cases {
@@ -221,6 +226,45 @@ func TestReconcileTerminalError(t *testing.T) {
 				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
 					return true
 				}),
+			expectedError: "`waiting condition #1` failure, terminal error: unrecoverable error",
+		},
+		{
+			name: "unrecoverableError with label",
+			testCase: New[TestControllerWithTerminalError, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm-0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return true
+				}, "not expecting a terminal error"),
+			expectedError: "`not expecting a terminal error` failure, terminal error: unrecoverable error",
+		},
+		{
+			name: "expected terminal error",
+			testCase: New[TestControllerWithTerminalError, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm-0", "cm").
+				ExpectTerminalError(nil),
+		},
+		{
+			name: "expected terminal error matching",
+			testCase: New[TestControllerWithTerminalError, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm-0", "cm").
+				ExpectTerminalError(func(err error) bool {
+					return strings.Contains(err.Error(), "unrecoverable")
+				}),
+		},
+		{
+			name: "unexpected terminal error",
+			testCase: New[TestControllerWithTerminalError, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm-0", "cm").
+				ExpectTerminalError(k8serr.IsNotFound, "expected to fail"),
+			expectedError: "`expected to fail` failure, unexpected terminal error: unrecoverable error",
 		},
 	}
 	for _, tc := range cases {
@@ -230,6 +274,329 @@ func TestReconcileTerminalError(t *testing.T) {
 	}
 }
 
+// TestControllerWithRequeueAfter counts the number of reconciles in the
+// ConfigMap data, and asks to be requeued after one minute.
+type TestControllerWithRequeueAfter struct {
+	client.Client
+}
+
+func (s TestControllerWithRequeueAfter) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cm := &corev1.ConfigMap{}
+	if err := s.Get(ctx, req.NamespacedName, cm); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data["reconciles"] = strconv.Itoa(reconciles(cm) + 1)
+	if err := s.Update(ctx, cm); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: time.Minute}, nil
+}
+
+func reconciles(cm *corev1.ConfigMap) int {
+	n, _ := strconv.Atoi(cm.Data["reconciles"])
+	return n
+}
+
+func TestReconcileRequeueAfter(t *testing.T) {
+	newRequeueScenario := func() Scenario[TestControllerWithRequeueAfter, *corev1.ConfigMap] {
+		return New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+			WithSchemes(corev1.AddToScheme)
+	}
+
+	cases := []testCase{
+		{
+			name: "reconcile after the deadline",
+			testCase: newRequeueScenario().
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				AdvanceTime(59 * time.Second).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				AdvanceTime(time.Second).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 2
+				}),
+		},
+		{
+			name: "deadline not elapsed",
+			testCase: newRequeueScenario().
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				AdvanceTime(30*time.Second).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 2
+				}, "expected to fail"),
+			expectedError: "`expected to fail` not satisfied, next reconcile scheduled in 30s",
+		},
+		{
+			name: "non-positive duration",
+			testCase: newRequeueScenario().
+				Setup(testScenarioBuilder{}).
+				AdvanceTime(0).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}),
+			expectedError: "step `advance time by 0s` failure: invalid duration 0s, the scenario clock can only move forward",
+		},
+		{
+			name: "object change triggers a new reconcile",
+			testCase: newRequeueScenario().
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					obj.Labels = map[string]string{"changed": "true"}
+					if err := client.Update(context.Background(), obj); err != nil {
+						t.Fatal(err)
+					}
+				}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 2
+				}),
+		},
+		{
+			name: "a new request is immediately reconciled",
+			testCase: newRequeueScenario().
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 2
+				}),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestControllerWithRequeueAfter, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
+
+// TestControllerWithConflict always fails when updating the requested object,
+// since it uses a stale resource version.
+type TestControllerWithConflict struct {
+	client.Client
+}
+
+func (s TestControllerWithConflict) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cm := &corev1.ConfigMap{}
+	if err := s.Get(ctx, req.NamespacedName, cm); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	cm.ResourceVersion = "1"
+	if err := s.Update(ctx, cm); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+func TestReconcileExpectations(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "expected result",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}).
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					if reconciles(obj) != 1 {
+						t.Fatalf("expected one reconcile, found %d", reconciles(obj))
+					}
+				}),
+		},
+		{
+			name: "unexpected result",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{Requeue: true}, "expected to fail"),
+			expectedError: "`expected to fail` failure, expected result {Requeue:true RequeueAfter:0s} but received {Requeue:false RequeueAfter:1m0s}",
+		},
+		{
+			name: "expected result not yet due",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}).
+				ExpectResult(ctrl.Result{RequeueAfter: time.Minute}, "expected to fail"),
+			expectedError: "`expected to fail` failure, next reconcile scheduled in 1m0s",
+		},
+		{
+			name: "expected error",
+			testCase: New[TestControllerWithConflict, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectError(k8serr.IsConflict),
+		},
+		{
+			name: "any error",
+			testCase: New[TestControllerWithConflict, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectError(nil),
+		},
+		{
+			name: "unexpected error",
+			testCase: New[TestControllerWithConflict, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectError(k8serr.IsNotFound, "expected to fail"),
+			expectedError: "`expected to fail` failure, unexpected error: Operation cannot be fulfilled on configmaps \"cm0\": object was modified",
+		},
+		{
+			name: "error expected but not received",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectError(nil, "expected to fail"),
+			expectedError: "`expected to fail` failure, expected an error but none received",
+		},
+		{
+			name: "result expected but error received",
+			testCase: New[TestControllerWithConflict, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectResult(ctrl.Result{}, "expected to fail"),
+			expectedError: "`expected to fail` failure, expected result {Requeue:false RequeueAfter:0s} but received error: Operation cannot be fulfilled on configmaps \"cm0\": object was modified",
+		},
+		{
+			name: "terminal error not received",
+			testCase: New[TestControllerWithConflict, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithMaxReconciles(3).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ExpectTerminalError(nil, "expected to fail"),
+			expectedError: "`expected to fail` not satisfied, no terminal error after 3 reconcile loops",
+		},
+		{
+			name: "last error reported",
+			testCase: New[TestControllerWithConflict, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithMaxReconciles(3).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return false
+				}, "expected to fail"),
+			expectedError: "`expected to fail` not satisfied, too many reconcile loops (3), last reconcile error: Operation cannot be fulfilled on configmaps \"cm0\": object was modified",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			switch tc.testCase.(type) {
+			case *scenario[TestControllerWithRequeueAfter, *corev1.ConfigMap]:
+				testScenario[TestControllerWithRequeueAfter, *corev1.ConfigMap](t, tc)
+			default:
+				testScenario[TestControllerWithConflict, *corev1.ConfigMap](t, tc)
+			}
+		})
+	}
+}
+
+func TestReconcileUntilIdle(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "idle after a single reconcile",
+			testCase: newTestScenario().
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilIdle(),
+		},
+		{
+			name: "requeue after is not pending work",
+			testCase: New[TestControllerWithRequeueAfter, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilIdle().
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 1
+				}, "reconciled once").
+				AdvanceTime(time.Minute).
+				ReconcileUntilIdle().
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return reconciles(obj) == 2
+				}, "reconciled again after the deadline"),
+		},
+		{
+			name: "hot loop",
+			testCase: New[TestControllerWithConflict, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				WithMaxReconciles(3).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilIdle("settle"),
+			expectedError: "`settle` not satisfied, controller not idle after 3 reconcile loops, last reconcile error: Operation cannot be fulfilled on configmaps \"cm0\": object was modified",
+		},
+		{
+			name: "terminal error",
+			testCase: New[TestControllerWithTerminalError, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm0", "cm").
+				ReconcileUntilIdle(),
+			expectedError: "`waiting condition #1` failure, terminal error: unrecoverable error",
+		},
+		{
+			name: "watch triggered events",
+			testCase: newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}).
+				Owns(&corev1.Secret{}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntilIdle().
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "cm1", Namespace: "cm"}}
+					_ = client.Delete(context.Background(), secret)
+				}).
+				ReconcileUntilIdle().
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0") && secretExists(client, "cm1") && secretExists(client, "cm2")
+				}),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			switch tc.testCase.(type) {
+			case *scenario[TestController, *corev1.ConfigMap]:
+				testScenario[TestController, *corev1.ConfigMap](t, tc)
+			case *scenario[TestControllerWithRequeueAfter, *corev1.ConfigMap]:
+				testScenario[TestControllerWithRequeueAfter, *corev1.ConfigMap](t, tc)
+			case *scenario[TestControllerWithConflict, *corev1.ConfigMap]:
+				testScenario[TestControllerWithConflict, *corev1.ConfigMap](t, tc)
+			case *scenario[TestControllerWithTerminalError, *corev1.ConfigMap]:
+				testScenario[TestControllerWithTerminalError, *corev1.ConfigMap](t, tc)
+			default:
+				testScenario[TestControllerWithWatches, *corev1.ConfigMap](t, tc)
+			}
+		})
+	}
+}
+
 func testScenario[R reconcile.Reconciler, T client.Object](t *testing.T, tc testCase) {
 	t.Helper()
 	s, ok := tc.testCase.(*scenario[R, T])
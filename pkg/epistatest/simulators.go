@@ -0,0 +1,105 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// maxSimulatorReconciles limits the reconciles executed by the simulators
+	// (and the garbage collector actions) every time the simulated cluster is stepped.
+	maxSimulatorReconciles = 100
+)
+
+// Simulator mimics a behavior of a real cluster, usually provided by the
+// kube-controller-manager or by the kubelet, so that the reconciler could
+// observe its effects (see WithSimulators). A simulator is a reconciler
+// itself, set up like a controller.
+type Simulator interface {
+	reconcile.Reconciler
+	// AddToScheme registers the types handled by the simulator.
+	AddToScheme(s *runtime.Scheme) error
+	// SetupWithManager registers the simulator watches. The manager client
+	// must be used by the simulator for its own operations.
+	SetupWithManager(mgr manager.Manager) error
+}
+
+// clockedSimulator is implemented by the simulators relying on the scenario clock,
+// which is provided again for every run.
+type clockedSimulator interface {
+	useClock(clock clock.PassiveClock)
+}
+
+// setupSimulators sets up the configured simulators, which share the scenario
+// cluster and clock like the controllers. Their calls are neither recorded
+// nor affected by the injected faults.
+func (s *scenario[R, T]) setupSimulators(ctx context.Context, mapper meta.RESTMapper) error {
+	s.simulations = nil
+
+	deps := s.deps
+	deps.Client = s.client
+	for _, sim := range s.simulators {
+		name := reflect.TypeOf(sim).String()
+		queue := newRequestQueue(s.clock)
+		sources, _, err := captureWatches(ctx, deps, mapper, queue, sim.SetupWithManager)
+		if err != nil {
+			return fmt.Errorf("simulator %s: %w", name, err)
+		}
+		watchers, err := newWatchers(sources, deps.Scheme, mapper)
+		if err != nil {
+			return fmt.Errorf("simulator %s: %w", name, err)
+		}
+		if c, ok := sim.(clockedSimulator); ok {
+			c.useClock(s.clock)
+		}
+		s.simulations = append(s.simulations, &controller{
+			name:       name,
+			reconciler: sim,
+			queue:      queue,
+			watchers:   watchers,
+		})
+	}
+	return nil
+}
+
+// stepSimulators reconciles all the requests ready for the simulators, in their
+// registration order, until the simulated cluster settles. When enabled, the
+// garbage collector runs first. An error is returned if the cluster does not
+// settle within maxSimulatorReconciles, for example when a simulator keeps
+// changing the objects it watches.
+func (s *scenario[R, T]) stepSimulators() error {
+	ctx := log.IntoContext(context.Background(), s.deps.Logger)
+	var last string
+	for i := 0; i < maxSimulatorReconciles; i++ {
+		if s.collector != nil && s.collector.collect(ctx) {
+			last = "garbage collector"
+			continue
+		}
+
+		var next *controller
+		for _, sim := range s.simulations {
+			if sim.queue.Len() > 0 {
+				next = sim
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+
+		req, _ := next.queue.Get()
+		result, err := next.reconciler.Reconcile(ctx, req)
+		next.queue.requeue(req, result, err)
+		next.queue.Done(req)
+		last = fmt.Sprintf("simulator %s (%s)", next.name, req)
+	}
+	return fmt.Errorf("simulated cluster not settled after %d reconciles, last by %s", maxSimulatorReconciles, last)
+}
@@ -0,0 +1,395 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rt "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// TestWorkloadController runs a web server and a migration job for every configmap,
+// and it reports in the configmap when they are ready.
+type TestWorkloadController struct {
+	client.Client
+	Scheme *rt.Scheme
+}
+
+func (s TestWorkloadController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cm := &corev1.ConfigMap{}
+	if err := s.Get(ctx, req.NamespacedName, cm); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	labels := map[string]string{"app": cm.Name}
+
+	deploy := &appsv1.Deployment{ObjectMeta: v1.ObjectMeta{Name: cm.Name, Namespace: cm.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, s.Client, deploy, func() error {
+		deploy.Spec.Replicas = ptr.To(int32(2))
+		deploy.Spec.Selector = &v1.LabelSelector{MatchLabels: labels}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{{Name: "web", Image: cm.Data["image"]}}
+		return controllerutil.SetControllerReference(cm, deploy, s.Scheme)
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	svc := &corev1.Service{ObjectMeta: v1.ObjectMeta{Name: cm.Name, Namespace: cm.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, s.Client, svc, func() error {
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{{Name: "http", Port: 80}}
+		return controllerutil.SetControllerReference(cm, svc, s.Scheme)
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	job := &batchv1.Job{ObjectMeta: v1.ObjectMeta{Name: cm.Name + "-migration", Namespace: cm.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, s.Client, job, func() error {
+		job.Spec.Completions = ptr.To(int32(2))
+		job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+		job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "migrate", Image: "migrate"}}
+		return controllerutil.SetControllerReference(cm, job, s.Scheme)
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	ready := deploy.Status.ReadyReplicas == 2 && meta.IsStatusConditionTrue(jobConditions(job), string(batchv1.JobComplete))
+	if cm.Data["ready"] == "true" || !ready {
+		return ctrl.Result{}, nil
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data["ready"] = "true"
+	return ctrl.Result{}, s.Update(ctx, cm)
+}
+
+func (s TestWorkloadController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.ConfigMap{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&batchv1.Job{}).
+		Complete(s)
+}
+
+func jobConditions(job *batchv1.Job) []v1.Condition {
+	var conditions []v1.Condition
+	for _, c := range job.Status.Conditions {
+		conditions = append(conditions, v1.Condition{Type: string(c.Type), Status: v1.ConditionStatus(c.Status)})
+	}
+	return conditions
+}
+
+func newTestScenarioWithSimulators() Scenario[TestWorkloadController, *corev1.ConfigMap] {
+	return New[TestWorkloadController, *corev1.ConfigMap]().
+		WithSchemes(corev1.AddToScheme, appsv1.AddToScheme, batchv1.AddToScheme).
+		FromSetupWithManager(TestWorkloadController.SetupWithManager)
+}
+
+func webSetup() []client.Object {
+	return []client.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: "cm"},
+			Data:       map[string]string{"image": "web:v1"},
+		},
+	}
+}
+
+func podsFor(c client.Client, app string) []corev1.Pod {
+	pods := &corev1.PodList{}
+	if err := c.List(context.Background(), pods, client.InNamespace("cm"), client.MatchingLabels{"app": app}); err != nil {
+		return nil
+	}
+	return pods.Items
+}
+
+func TestSimulators(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "no simulators",
+			testCase: newTestScenarioWithSimulators().
+				SetupObjects(webSetup).
+				ReconcileUntilIdle().
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return obj.Data["ready"] == "true"
+				}, "ready"),
+			expectedError: "`ready` not satisfied, no pending reconcile requests",
+		},
+		{
+			name: "deployment and job completed",
+			testCase: newTestScenarioWithSimulators().
+				WithSimulators(DeploymentSimulator(), ReplicaSetSimulator(), PodSimulator(), JobSimulator()).
+				SetupObjects(webSetup).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return obj.Data["ready"] == "true"
+				}, "ready").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					pods := podsFor(client, "web")
+					return len(pods) == 2 && podReady(&pods[0]) && podReady(&pods[1])
+				}, "web pods ready"),
+		},
+		{
+			name: "rollout replaces the pods",
+			testCase: newTestScenarioWithSimulators().
+				WithSimulators(DeploymentSimulator(), ReplicaSetSimulator(), PodSimulator()).
+				SetupObjects(webSetup).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return len(podsFor(client, "web")) == 2
+				}).
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					obj.Data["image"] = "web:v2"
+					_ = client.Update(context.Background(), obj)
+				}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					pods := podsFor(client, "web")
+					return len(pods) == 2 && pods[0].Spec.Containers[0].Image == "web:v2" && pods[1].Spec.Containers[0].Image == "web:v2"
+				}, "new pods"),
+		},
+		{
+			name: "service endpoints",
+			testCase: newTestScenarioWithSimulators().
+				WithSimulators(DeploymentSimulator(), ReplicaSetSimulator(), PodSimulator(), EndpointsSimulator()).
+				SetupObjects(webSetup).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					endpoints := &corev1.Endpoints{}
+					if err := client.Get(context.Background(), types.NamespacedName{Name: "web", Namespace: "cm"}, endpoints); err != nil {
+						return false
+					}
+					return len(endpoints.Subsets) == 1 && len(endpoints.Subsets[0].Addresses) == 2 && endpoints.Subsets[0].Ports[0].Port == 80
+				}, "endpoints"),
+		},
+		{
+			name: "job times from the scenario clock",
+			testCase: newTestScenarioWithSimulators().
+				WithSimulators(JobSimulator()).
+				SetupObjects(webSetup).
+				AdvanceTime(time.Hour).
+				ReconcileUntil(func(c client.Client, obj *corev1.ConfigMap) bool {
+					job := &batchv1.Job{}
+					if err := c.Get(context.Background(), types.NamespacedName{Name: "web-migration", Namespace: "cm"}, job); err != nil {
+						return false
+					}
+					expected := obj.CreationTimestamp.Add(time.Hour)
+					return job.Status.StartTime != nil && job.Status.StartTime.Time.Equal(expected) &&
+						job.Status.CompletionTime != nil && job.Status.CompletionTime.Time.Equal(expected)
+				}, "job times"),
+		},
+		{
+			name: "simulator not settling",
+			testCase: newTestScenarioWithSimulators().
+				WithSimulators(&restlessSimulator{}).
+				SetupObjects(webSetup).
+				ReconcileUntilIdle(),
+			expectedError: "simulated cluster not settled after 100 reconciles, last by simulator *epistatest.restlessSimulator (cm/web)",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestWorkloadController, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
+
+// restlessSimulator changes every configmap it observes, so it never settles.
+type restlessSimulator struct {
+	client client.Client
+}
+
+func (rs *restlessSimulator) AddToScheme(s *rt.Scheme) error {
+	return corev1.AddToScheme(s)
+}
+
+func (rs *restlessSimulator) SetupWithManager(mgr ctrl.Manager) error {
+	rs.client = mgr.GetClient()
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("restless-simulator").
+		For(&corev1.ConfigMap{}).
+		Complete(rs)
+}
+
+func (rs *restlessSimulator) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cm := &corev1.ConfigMap{}
+	if err := rs.client.Get(ctx, req.NamespacedName, cm); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	cm.Annotations = map[string]string{"observed": cm.ResourceVersion}
+	return ctrl.Result{}, rs.client.Update(ctx, cm)
+}
+
+func TestNodeSimulator(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "nodes ready",
+			testCase: New[TestController, *corev1.Node]().
+				WithSchemes(corev1.AddToScheme).
+				WithSimulators(NodeSimulator()).
+				SetupObjects(func() []client.Object {
+					return []client.Object{&corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "node"}}}
+				}).
+				NextRequest("node").
+				ReconcileUntil(func(client client.Client, node *corev1.Node) bool {
+					for _, c := range node.Status.Conditions {
+						if c.Type == corev1.NodeReady {
+							return c.Status == corev1.ConditionTrue
+						}
+					}
+					return false
+				}, "node ready"),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestController, *corev1.Node](t, tc)
+		})
+	}
+}
+
+func webServiceSetup() []client.Object {
+	labels := map[string]string{"app": "web"}
+	return []client.Object{
+		&corev1.Service{
+			ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports:    []corev1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromString("http")}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{Name: "web-0", Namespace: "ns", Labels: labels},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "web", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+			}},
+		},
+		&corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{Name: "web-1", Namespace: "ns", Labels: labels},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+		},
+	}
+}
+
+// webEndpoints returns the names of the pods in the web endpoints, with their port.
+func webEndpoints(c client.Client) ([]string, error) {
+	endpoints := &corev1.Endpoints{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "web", Namespace: "ns"}, endpoints); err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			for _, port := range subset.Ports {
+				addresses = append(addresses, fmt.Sprintf("%s:%d", address.TargetRef.Name, port.Port))
+			}
+		}
+	}
+	return addresses, nil
+}
+
+func TestEndpointsSimulator(t *testing.T) {
+	webEndpointsReady := func(c client.Client, obj *corev1.Service) bool {
+		addresses, err := webEndpoints(c)
+		return err == nil && slices.Equal(addresses, []string{"web-0:8080"})
+	}
+	cases := []testCase{
+		{
+			name: "named target port",
+			testCase: New[TestController, *corev1.Service]().
+				WithSchemes(corev1.AddToScheme).
+				WithSimulators(PodSimulator(), EndpointsSimulator()).
+				SetupObjects(webServiceSetup).
+				NextRequest("web", "ns").
+				ReconcileUntil(webEndpointsReady, "endpoints"),
+		},
+		{
+			name: "relabeled pod",
+			testCase: New[TestController, *corev1.Service]().
+				WithSchemes(corev1.AddToScheme).
+				WithSimulators(PodSimulator(), EndpointsSimulator()).
+				SetupObjects(webServiceSetup).
+				NextRequest("web", "ns").
+				ReconcileUntil(webEndpointsReady, "endpoints").
+				Then(func(c client.Client, obj *corev1.Service) {
+					pod := &corev1.Pod{}
+					if err := c.Get(context.Background(), types.NamespacedName{Name: "web-0", Namespace: "ns"}, pod); err == nil {
+						pod.Labels = map[string]string{"app": "other"}
+						_ = c.Update(context.Background(), pod)
+					}
+				}).
+				ReconcileUntil(func(c client.Client, obj *corev1.Service) bool {
+					addresses, err := webEndpoints(c)
+					return err == nil && len(addresses) == 0
+				}, "pod removed"),
+		},
+		{
+			name: "deleted service",
+			testCase: New[TestController, *corev1.Service]().
+				WithSchemes(corev1.AddToScheme).
+				WithSimulators(PodSimulator(), EndpointsSimulator()).
+				SetupObjects(webServiceSetup).
+				NextRequest("web", "ns").
+				ReconcileUntil(webEndpointsReady, "endpoints").
+				Then(func(c client.Client, obj *corev1.Service) {
+					_ = c.Delete(context.Background(), obj)
+				}).
+				ReconcileUntil(func(c client.Client, obj *corev1.Service) bool {
+					_, err := webEndpoints(c)
+					return k8serr.IsNotFound(err)
+				}, "endpoints removed"),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestController, *corev1.Service](t, tc)
+		})
+	}
+}
+
+func TestOwnedPodsOrder(t *testing.T) {
+	rs := &appsv1.ReplicaSet{ObjectMeta: v1.ObjectMeta{Name: "rs", Namespace: "ns", UID: "rs-uid"}}
+	created := v1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var objs []client.Object
+	for _, pod := range []struct {
+		name    string
+		created v1.Time
+	}{
+		{name: "rs-10", created: created},
+		{name: "rs-9", created: created},
+		{name: "rs-2", created: v1.NewTime(created.Add(-time.Minute))},
+		{name: "rs-1", created: created},
+	} {
+		objs = append(objs, &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+			Name:              pod.name,
+			Namespace:         "ns",
+			CreationTimestamp: pod.created,
+			OwnerReferences: []v1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: ptr.To(true)},
+			},
+		}})
+	}
+	c := fake.NewClientBuilder().WithObjects(objs...).Build()
+
+	pods, err := ownedPods(context.Background(), c, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	if expected := []string{"rs-2", "rs-1", "rs-9", "rs-10"}; !slices.Equal(names, expected) {
+		t.Fatalf("expected pods %v, found %v", expected, names)
+	}
+}
@@ -0,0 +1,292 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// NextRequestFor is similar to NextRequest, but the following steps will receive the
+// object of the current request typed as U, instead of the scenario object type T.
+// Useful when the reconciler handles requests for different kinds. The steps will be
+// typed again on T (or any other type) by a subsequent NextRequestFor, ie:
+//
+//	NextRequestFor[*corev1.Node](s, "node-0").
+//		ReconcileUntil(func(c client.Client, node *corev1.Node) bool { ... })
+//
+// Like NextRequest, the test will fail if the request refers to an object of a
+// different kind than U, when no U could exist for the request.
+func NextRequestFor[U client.Object, T client.Object](s _reconcileNextRequest[T], name string, namespace ...string) _reconcileLoop[U] {
+	steps := s.(stepsBuilder[T])
+	steps.nextRequestFor(func() client.Object { return newObject[U]() }, name, namespace...)
+	return &typedSteps[T, U]{steps: steps}
+}
+
+// stepsBuilder is implemented by the scenario (and by the typed steps), and allows
+// to add steps typed on an object type different from T.
+type stepsBuilder[T client.Object] interface {
+	_reconcileAction[T]
+	nextRequestFor(newObj func() client.Object, name string, namespace ...string)
+	deleteObjectFor(newObj func() client.Object, name string, namespace ...string)
+	currentObject(obj client.Object) error
+	selectController(name string)
+	addInvariant(labels []string, defaultName string, check func(c client.Client, obj T) error) _reconcileAction[T]
+	then(action func(client client.Client, obj T) error, labels ...string) _reconcileNextRequest[T]
+}
+
+// typedSteps adds to the scenario steps typed on U, by adapting them to T.
+type typedSteps[T client.Object, U client.Object] struct {
+	steps stepsBuilder[T]
+}
+
+// current fetches the object of the current request as U. An empty instance is
+// returned if the object was not found.
+func (ts *typedSteps[T, U]) current() (U, error) {
+	obj := newObject[U]()
+	return obj, ts.steps.currentObject(obj)
+}
+
+func (ts *typedSteps[T, U]) nextRequestFor(newObj func() client.Object, name string, namespace ...string) {
+	ts.steps.nextRequestFor(newObj, name, namespace...)
+}
+
+func (ts *typedSteps[T, U]) deleteObjectFor(newObj func() client.Object, name string, namespace ...string) {
+	ts.steps.deleteObjectFor(newObj, name, namespace...)
+}
+
+func (ts *typedSteps[T, U]) currentObject(obj client.Object) error {
+	return ts.steps.currentObject(obj)
+}
+
+func (ts *typedSteps[T, U]) selectController(name string) {
+	ts.steps.selectController(name)
+}
+
+func (ts *typedSteps[T, U]) NextRequest(name string, namespace ...string) _reconcileLoop[U] {
+	ts.nextRequestFor(func() client.Object { return newObject[U]() }, name, namespace...)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) NextRequestObject(nextReqObj func() client.Object) _reconcileLoop[U] {
+	ts.steps.NextRequestObject(nextReqObj)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ReconcileAll() _reconcileNextRequest[U] {
+	ts.selectController(allControllers)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) AdvanceTime(d time.Duration) _reconcileNextRequest[U] {
+	ts.steps.AdvanceTime(d)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) DeleteObject(name string, namespace ...string) _reconcileNextRequest[U] {
+	ts.deleteObjectFor(func() client.Object { return newObject[U]() }, name, namespace...)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ReconcileUntil(waitFor func(client client.Client, obj U) bool, labels ...string) _reconcileAction[U] {
+	return ts.ReconcileUntilE(func(client client.Client, obj U) error {
+		if !waitFor(client, obj) {
+			return errNotSatisfied
+		}
+		return nil
+	}, labels...)
+}
+
+func (ts *typedSteps[T, U]) ReconcileUntilE(waitFor func(client client.Client, obj U) error, labels ...string) _reconcileAction[U] {
+	ts.steps.ReconcileUntilE(func(c client.Client, _ T) error {
+		obj, err := ts.current()
+		if err != nil {
+			return err
+		}
+		return waitFor(c, obj)
+	}, labels...)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ReconcileUntilMatch(matcher Matcher, labels ...string) _reconcileAction[U] {
+	return ts.ReconcileUntilE(func(_ client.Client, obj U) error {
+		return matcher(obj)
+	}, labels...)
+}
+
+func (ts *typedSteps[T, U]) ExpectFinalizerAdded(finalizer string, labels ...string) _reconcileAction[U] {
+	return ts.ReconcileUntilMatch(HasFinalizer(finalizer), labels...)
+}
+
+func (ts *typedSteps[T, U]) ExpectDeleted(labels ...string) _reconcileAction[U] {
+	return ts.ReconcileUntilE(func(_ client.Client, obj U) error {
+		return deleted(obj)
+	}, labels...)
+}
+
+func (ts *typedSteps[T, U]) ExpectObservedGenerationCurrent(labels ...string) _reconcileAction[U] {
+	return ts.ReconcileUntilMatch(generationObserved, labels...)
+}
+
+func (ts *typedSteps[T, U]) ReconcileUntilIdle(labels ...string) _reconcileAction[U] {
+	ts.steps.ReconcileUntilIdle(labels...)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ExpectResult(result reconcile.Result, labels ...string) _reconcileAction[U] {
+	ts.steps.ExpectResult(result, labels...)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ExpectError(matcher func(err error) bool, labels ...string) _reconcileAction[U] {
+	ts.steps.ExpectError(matcher, labels...)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ExpectTerminalError(matcher func(err error) bool, labels ...string) _reconcileAction[U] {
+	ts.steps.ExpectTerminalError(matcher, labels...)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) Always(f func(client client.Client, obj U) bool, labels ...string) _reconcileAction[U] {
+	return ts.addInvariant(labels, "always", func(c client.Client, obj U) error {
+		if !f(c, obj) {
+			return errNotSatisfied
+		}
+		return nil
+	})
+}
+
+func (ts *typedSteps[T, U]) Never(f func(client client.Client, obj U) bool, labels ...string) _reconcileAction[U] {
+	return ts.addInvariant(labels, "never", func(c client.Client, obj U) error {
+		if f(c, obj) {
+			return fmt.Errorf("condition satisfied")
+		}
+		return nil
+	})
+}
+
+func (ts *typedSteps[T, U]) addInvariant(labels []string, defaultName string, check func(c client.Client, obj U) error) _reconcileAction[U] {
+	ts.steps.addInvariant(labels, defaultName, func(c client.Client, _ T) error {
+		obj, err := ts.current()
+		if err != nil {
+			return err
+		}
+		return check(c, obj)
+	})
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ExpectCalls(verb Verb, gvk schema.GroupVersionKind, n int) _reconcileAction[U] {
+	ts.steps.ExpectCalls(verb, gvk, n)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ExpectWrites(n int) _reconcileAction[U] {
+	ts.steps.ExpectWrites(n)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) ExpectNoWrites() _reconcileAction[U] {
+	ts.steps.ExpectNoWrites()
+	return ts
+}
+
+func (ts *typedSteps[T, U]) VerifyCalls(check func(calls []Call) error) _reconcileAction[U] {
+	ts.steps.VerifyCalls(check)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) Then(action func(client client.Client, obj U), labels ...string) _reconcileNextRequest[U] {
+	return ts.then(func(c client.Client, obj U) error {
+		action(c, obj)
+		return nil
+	}, labels...)
+}
+
+func (ts *typedSteps[T, U]) then(action func(client client.Client, obj U) error, labels ...string) _reconcileNextRequest[U] {
+	ts.steps.then(func(c client.Client, _ T) error {
+		obj, err := ts.current()
+		if err != nil {
+			return err
+		}
+		return action(c, obj)
+	}, labels...)
+	return ts
+}
+
+func (ts *typedSteps[T, U]) Test(t *testing.T) {
+	t.Helper()
+	ts.steps.Test(t)
+}
+
+func (ts *typedSteps[T, U]) Case() Testable {
+	return ts.steps.Case()
+}
+
+func (ts *typedSteps[T, U]) FaultCampaign(err error) Testable {
+	return ts.steps.FaultCampaign(err)
+}
+
+// currentObject fetches the object of the current request. The object is left
+// empty if not found.
+func (s *scenario[R, T]) currentObject(obj client.Object) error {
+	if err := s.client.Get(context.Background(), s.current.NamespacedName, obj); err != nil && !k8serr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// verifyRequestKind checks that the request refers an object of the same kind of obj.
+// A request for an object not found is accepted (since it could be created later, or it
+// could have been deleted), even if an object of a different kind exists with the same
+// key, like a child named after its parent. A mismatch is reported only when no object of
+// the expected kind could exist for the key, since its scope is different, or when the
+// object is stored in a different version of the same kind.
+func (s *scenario[R, T]) verifyRequestKind(key types.NamespacedName, obj client.Object) error {
+	err := s.client.Get(context.Background(), key, obj)
+	if err == nil || !k8serr.IsNotFound(err) {
+		return err
+	}
+
+	scheme := s.client.Scheme()
+	expected, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return err
+	}
+	namespaced, err := s.client.IsObjectNamespaced(obj)
+	if err != nil {
+		return err
+	}
+	scopeMismatch := namespaced == (key.Namespace == "")
+	for _, gvk := range storedKinds(scheme, s.converter) {
+		if gvk == expected || (!scopeMismatch && gvk.GroupKind() != expected.GroupKind()) {
+			continue
+		}
+		other, err := scheme.New(gvk)
+		if err != nil {
+			continue
+		}
+		if s.client.Get(context.Background(), key, other.(client.Object)) != nil {
+			continue
+		}
+		if gvk.GroupKind() == expected.GroupKind() {
+			return fmt.Errorf("request %s refers to a %s %s, but %s was expected (see WithStorageVersion)", key, gvk.Kind, gvk.GroupVersion(), expected.GroupVersion())
+		}
+		return fmt.Errorf("request %s refers to a %s, but a %s was expected", key, gvk.Kind, expected.Kind)
+	}
+	return nil
+}
+
+// newObject returns a new empty instance of the object type U.
+func newObject[U client.Object]() U {
+	return reflect.New(reflect.TypeOf(*new(U)).Elem()).Interface().(U)
+}
@@ -0,0 +1,185 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TestControllerWithMultipleKinds labels with `reconciled` both the nodes and the
+// ConfigMaps referenced by the requests.
+type TestControllerWithMultipleKinds struct {
+	client.Client
+}
+
+func (s TestControllerWithMultipleKinds) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	for _, obj := range []client.Object{&corev1.Node{}, &corev1.ConfigMap{}} {
+		if err := s.Get(ctx, req.NamespacedName, obj); err != nil {
+			if k8serr.IsNotFound(err) {
+				continue
+			}
+			return ctrl.Result{}, err
+		}
+		obj.SetLabels(map[string]string{"reconciled": "true"})
+		return ctrl.Result{}, s.Update(ctx, obj)
+	}
+	return ctrl.Result{}, nil
+}
+
+func multipleKindsSetup() []client.Object {
+	return append(testScenarioBuilder{}.Build(), &corev1.Node{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "node",
+		},
+	})
+}
+
+func newTestScenarioWithMultipleKinds() _reconcileNextRequest[*corev1.ConfigMap] {
+	return New[TestControllerWithMultipleKinds, *corev1.ConfigMap]().
+		WithSchemes(corev1.AddToScheme).
+		SetupObjects(multipleKindsSetup)
+}
+
+func TestNextRequestFor(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "steps typed on a different kind",
+			testCase: NextRequestFor[*corev1.Node](newTestScenarioWithMultipleKinds(), "node").
+				ReconcileUntil(func(client client.Client, node *corev1.Node) bool {
+					return node.Name == "node" && node.Labels["reconciled"] == "true"
+				}).
+				Always(func(client client.Client, node *corev1.Node) bool {
+					return node.Name == "node"
+				}).
+				Then(func(client client.Client, node *corev1.Node) {
+					node.Labels = nil
+					_ = client.Update(context.Background(), node)
+				}).
+				ReconcileUntilMatch(HasLabel("reconciled", "true")).
+				Case(),
+		},
+		{
+			name: "back to the scenario type",
+			testCase: NextRequestFor[*corev1.ConfigMap](NextRequestFor[*corev1.Node](newTestScenarioWithMultipleKinds(), "node").
+				ReconcileUntilMatch(HasLabel("reconciled")), "cm0", "cm").
+				ReconcileUntil(func(client client.Client, cm *corev1.ConfigMap) bool {
+					return cm.Name == "cm0" && cm.Labels["reconciled"] == "true"
+				}).
+				Case(),
+		},
+		{
+			name: "typed next request on a different kind",
+			testCase: NextRequestFor[*corev1.Node](newTestScenarioWithMultipleKinds(), "node").
+				ReconcileUntilMatch(HasLabel("reconciled")).
+				NextRequest("cm0", "cm").
+				ReconcileUntilMatch(HasLabel("reconciled")).
+				Case(),
+			expectedError: "step `` failure: request cm/cm0 refers to a ConfigMap, but a Node was expected",
+		},
+		{
+			name: "kind mismatch",
+			testCase: NextRequestFor[*corev1.Secret](newTestScenarioWithMultipleKinds(), "node").
+				ReconcileUntilIdle().
+				Case(),
+			expectedError: "step `` failure: request /node refers to a Node, but a Secret was expected",
+		},
+		{
+			name: "object not found next to a child of another kind",
+			testCase: New[TestControllerWithMultipleKinds, *corev1.ConfigMap]().
+				WithSchemes(corev1.AddToScheme).
+				SetupObjects(func() []client.Object {
+					return []client.Object{&corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "cm0", Namespace: "cm"}}}
+				}).
+				NextRequest("cm0", "cm").
+				ReconcileUntil(func(client client.Client, cm *corev1.ConfigMap) bool {
+					return cm.Name == ""
+				}).
+				Case(),
+		},
+		{
+			name: "object not yet created",
+			testCase: NextRequestFor[*corev1.Node](newTestScenarioWithMultipleKinds(), "new-node").
+				ReconcileUntil(func(client client.Client, node *corev1.Node) bool {
+					return node.Name == ""
+				}).
+				Case(),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestControllerWithMultipleKinds, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
+
+// addFailingWidgetConversion overrides the conversion of the hub Widget to v1beta1, so
+// that reading an oversized widget as v1beta1 fails.
+func addFailingWidgetConversion(s *runtime.Scheme) error {
+	return s.AddConversionFunc((*Widget)(nil), (*WidgetV1beta1)(nil), func(a, b any, scope conversion.Scope) error {
+		src, dst := a.(*Widget), b.(*WidgetV1beta1)
+		if src.Spec.Size > 10 {
+			return fmt.Errorf("unsupported size %d", src.Spec.Size)
+		}
+		dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+		dst.Spec = src.Spec
+		return nil
+	})
+}
+
+func newFetchErrorScenario() _reconcileAction[*WidgetV1beta1] {
+	s := New[WidgetController, *Widget]().
+		WithSchemes(addWidgetsToScheme, addWidgetVersionsToScheme, addFailingWidgetConversion).
+		WithStorageVersion(&Widget{}).
+		SetupObjects(func() []client.Object {
+			return []client.Object{&Widget{ObjectMeta: v1.ObjectMeta{Name: "w0", Namespace: "ns"}, Spec: WidgetSpec{Size: 1}}}
+		})
+	return NextRequestFor[*WidgetV1beta1](s, "w0", "ns").
+		ReconcileUntilIdle().
+		Then(func(c client.Client, w *WidgetV1beta1) {
+			w.Spec.Size = 11
+			_ = c.Update(context.Background(), w)
+		}, "oversize").
+		ReconcileUntilIdle()
+}
+
+func TestTypedStepsFetchError(t *testing.T) {
+	const fetchErr = "converting test.epistatest.io/v1, Kind=Widget to test.epistatest.io/v1beta1, Kind=Widget: unsupported size 11"
+	cases := []testCase{
+		{
+			name: "then",
+			testCase: newFetchErrorScenario().
+				Then(func(client.Client, *WidgetV1beta1) {}, "noop").
+				Case(),
+			expectedError: "`noop` failure, " + fetchErr,
+		},
+		{
+			name: "never",
+			testCase: newFetchErrorScenario().
+				Never(func(_ client.Client, w *WidgetV1beta1) bool {
+					return w.Spec.Size > 10
+				}, "oversized").
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Case(),
+			expectedError: "`waiting condition #2` failure, invariant `oversized` violated after reconcile #2 (ns/w0): " + fetchErr,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The invariant failure reports also the (non deterministic) object dump.
+			err := tc.testCase.(*scenario[WidgetController, *Widget]).test()
+			if err == nil || !strings.HasPrefix(err.Error(), tc.expectedError) {
+				t.Fatalf("expected error: `%s`, but received `%v`", tc.expectedError, err)
+			}
+		})
+	}
+}
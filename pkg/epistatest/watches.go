@@ -0,0 +1,105 @@
+package epistatest
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// watchSource describes how the events on a given object type are mapped
+// to reconcile requests, similarly to a controller-runtime source.Kind.
+// The watches captured from a controller setup provide instead the informer
+// event handler registered by the controller source (see fakeManager).
+type watchSource struct {
+	object     client.Object
+	handler    func(scheme *runtime.Scheme, mapper meta.RESTMapper) handler.EventHandler
+	predicates []predicate.Predicate
+	informer   toolscache.ResourceEventHandler
+}
+
+// watcher is a watchSource ready to receive the scenario events.
+type watcher struct {
+	gvk        schema.GroupVersionKind
+	handler    handler.EventHandler
+	predicates []predicate.Predicate
+	informer   toolscache.ResourceEventHandler
+}
+
+func newWatchers(sources []watchSource, scheme *runtime.Scheme, mapper meta.RESTMapper) ([]watcher, error) {
+	var watchers []watcher
+	for _, src := range sources {
+		gvk, err := apiutil.GVKForObject(src.object, scheme)
+		if err != nil {
+			return nil, err
+		}
+		w := watcher{
+			gvk:        gvk,
+			predicates: src.predicates,
+			informer:   src.informer,
+		}
+		if src.handler != nil {
+			w.handler = src.handler(scheme, mapper)
+		}
+		watchers = append(watchers, w)
+	}
+	return watchers, nil
+}
+
+// notify delivers the event related to the change to the handler, if the
+// object kind is matching and all the predicates are satisfied. An informer
+// event handler evaluates the predicates by itself, and it enqueues the requests
+// in the queue of its own controller.
+func (w watcher) notify(gvk schema.GroupVersionKind, change objectChange, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if gvk != w.gvk {
+		return
+	}
+	if w.informer != nil {
+		switch {
+		case change.Old == nil:
+			w.informer.OnAdd(change.New, false)
+		case change.New == nil:
+			w.informer.OnDelete(change.Old)
+		default:
+			w.informer.OnUpdate(change.Old, change.New)
+		}
+		return
+	}
+
+	ctx := context.Background()
+	switch {
+	case change.Old == nil:
+		evt := event.CreateEvent{Object: change.New}
+		for _, p := range w.predicates {
+			if !p.Create(evt) {
+				return
+			}
+		}
+		w.handler.Create(ctx, evt, q)
+	case change.New == nil:
+		evt := event.DeleteEvent{Object: change.Old}
+		for _, p := range w.predicates {
+			if !p.Delete(evt) {
+				return
+			}
+		}
+		w.handler.Delete(ctx, evt, q)
+	default:
+		evt := event.UpdateEvent{ObjectOld: change.Old, ObjectNew: change.New}
+		for _, p := range w.predicates {
+			if !p.Update(evt) {
+				return
+			}
+		}
+		w.handler.Update(ctx, evt, q)
+	}
+}
@@ -0,0 +1,167 @@
+package epistatest
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rt "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// TestControllerWithWatches keeps a secret, owned by the reconciled
+// config map, with a copy of the config map data.
+type TestControllerWithWatches struct {
+	client.Client
+	Scheme *rt.Scheme
+}
+
+func (s TestControllerWithWatches) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cm := &corev1.ConfigMap{}
+	if err := s.Get(ctx, req.NamespacedName, cm); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      cm.Name,
+			Namespace: cm.Namespace,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, s.Client, secret, func() error {
+		secret.StringData = cm.Data
+		return controllerutil.SetControllerReference(cm, secret, s.Scheme)
+	})
+	return ctrl.Result{}, err
+}
+
+func secretExists(c client.Client, name string) bool {
+	return c.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "cm"}, &corev1.Secret{}) == nil
+}
+
+func newTestScenarioWithWatches() Scenario[TestControllerWithWatches, *corev1.ConfigMap] {
+	return New[TestControllerWithWatches, *corev1.ConfigMap]().
+		WithSchemes(corev1.AddToScheme)
+}
+
+func TestWatches(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "initial objects are enqueued",
+			testCase: newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0") && secretExists(client, "cm1") && secretExists(client, "cm2")
+				}),
+		},
+		{
+			name: "no pending requests",
+			testCase: newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}).
+				Setup(emptySetup{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return false
+				}),
+			expectedError: "`waiting condition #0` not satisfied, no pending reconcile requests",
+		},
+		{
+			name: "for predicates",
+			testCase: newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}, predicate.NewPredicateFuncs(func(obj client.Object) bool {
+					return obj.GetName() == "cm0"
+				})).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm1")
+				}, "wait for cm1 secret"),
+			expectedError: "`wait for cm1 secret` not satisfied, no pending reconcile requests",
+		},
+		{
+			name: "changes on the main resource",
+			testCase: newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}).
+				Owns(&corev1.Secret{}).
+				Setup(testScenarioBuilder{}).
+				NextRequest("cm1", "cm").
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm1")
+				}).
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					obj.Data = map[string]string{"key": "value"}
+					_ = client.Update(context.Background(), obj)
+				}).
+				ReconcileUntil(func(c client.Client, obj *corev1.ConfigMap) bool {
+					secret := &corev1.Secret{}
+					if err := c.Get(context.Background(), client.ObjectKeyFromObject(obj), secret); err != nil {
+						return false
+					}
+					return secret.StringData["key"] == "value"
+				}),
+		},
+		{
+			name: "owned resource deleted",
+			testCase: newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}).
+				Owns(&corev1.Secret{}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0") && secretExists(client, "cm1") && secretExists(client, "cm2")
+				}).
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "cm0", Namespace: "cm"}}
+					_ = client.Delete(context.Background(), secret)
+				}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0")
+				}, "secret recreated"),
+		},
+		{
+			name: "owned resource not watched",
+			testCase: newTestScenarioWithWatches().
+				For(&corev1.ConfigMap{}).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0") && secretExists(client, "cm1") && secretExists(client, "cm2")
+				}).
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "cm0", Namespace: "cm"}}
+					_ = client.Delete(context.Background(), secret)
+				}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm0")
+				}, "secret recreated"),
+			expectedError: "`secret recreated` not satisfied, no pending reconcile requests",
+		},
+		{
+			name: "watches with map function",
+			testCase: newTestScenarioWithWatches().
+				Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []ctrl.Request {
+					return []ctrl.Request{{NamespacedName: types.NamespacedName{Name: "cm2", Namespace: "cm"}}}
+				})).
+				Setup(testScenarioBuilder{}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return !secretExists(client, "cm2")
+				}).
+				Then(func(client client.Client, obj *corev1.ConfigMap) {
+					node := &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "node0"}}
+					_ = client.Create(context.Background(), node)
+				}).
+				ReconcileUntil(func(client client.Client, obj *corev1.ConfigMap) bool {
+					return secretExists(client, "cm2") && !secretExists(client, "cm0")
+				}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testScenario[TestControllerWithWatches, *corev1.ConfigMap](t, tc)
+		})
+	}
+}
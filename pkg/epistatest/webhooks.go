@@ -0,0 +1,142 @@
+package epistatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Webhook describes the admission webhooks of a resource type, usually the same
+// defaulter and validator registered with the controller-runtime webhook builder
+// (see WithWebhooks).
+type Webhook struct {
+	// For is the resource type handled by the webhooks.
+	For client.Object
+	// Defaulter is the optional mutating webhook.
+	Defaulter admission.CustomDefaulter
+	// Validator is the optional validating webhook.
+	Validator admission.CustomValidator
+	// Name identifies the webhook in the denial messages. If empty, the webhook
+	// path generated by controller-runtime is used, ie validate-group-version-kind.
+	Name string
+}
+
+// name returns the webhook name for the given kind, with the mutate or validate prefix.
+func (w Webhook) name(prefix string, gvk schema.GroupVersionKind) string {
+	if w.Name != "" {
+		return w.Name
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", prefix, strings.ReplaceAll(gvk.Group, ".", "-"), gvk.Version, strings.ToLower(gvk.Kind))
+}
+
+// admissionWebhooks contains the webhooks registered for every kind, in the
+// registration order.
+type admissionWebhooks map[schema.GroupVersionKind][]Webhook
+
+func newAdmissionWebhooks(webhooks []Webhook, scheme *runtime.Scheme) (admissionWebhooks, error) {
+	aw := admissionWebhooks{}
+	for _, w := range webhooks {
+		gvk, err := apiutil.GVKForObject(w.For, scheme)
+		if err != nil {
+			return nil, fmt.Errorf("webhook for %T: %w", w.For, err)
+		}
+		aw[gvk] = append(aw[gvk], w)
+	}
+	return aw, nil
+}
+
+// mutate invokes all the defaulters registered for the kind of the object, like the API
+// server does with the mutating webhooks. A denial is returned as an API error.
+func (aw admissionWebhooks) mutate(ctx context.Context, gvk schema.GroupVersionKind, obj client.Object) error {
+	for _, w := range aw[gvk] {
+		if w.Defaulter == nil {
+			continue
+		}
+		if err := w.Defaulter.Default(ctx, obj); err != nil {
+			return denied(w.name("mutate", gvk), err)
+		}
+	}
+	return nil
+}
+
+// validate invokes all the validators registered for the kind of the object, like the
+// API server does with the validating webhooks. The old object is nil for a creation.
+// A denial is returned as an API error.
+func (aw admissionWebhooks) validate(ctx context.Context, gvk schema.GroupVersionKind, obj client.Object, old client.Object) error {
+	for _, w := range aw[gvk] {
+		if w.Validator == nil {
+			continue
+		}
+		var err error
+		if old == nil {
+			_, err = w.Validator.ValidateCreate(ctx, obj)
+		} else {
+			_, err = w.Validator.ValidateUpdate(ctx, old, obj)
+		}
+		if err != nil {
+			return denied(w.name("validate", gvk), err)
+		}
+	}
+	return nil
+}
+
+// admitDelete invokes the validators registered for the kind of the object being deleted.
+func (aw admissionWebhooks) admitDelete(ctx context.Context, obj client.Object, scheme *runtime.Scheme) error {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return nil
+	}
+	ctx = admissionContext(ctx, gvk, obj, admissionv1.Delete)
+	for _, w := range aw[gvk] {
+		if w.Validator == nil {
+			continue
+		}
+		if _, err := w.Validator.ValidateDelete(ctx, obj); err != nil {
+			return denied(w.name("validate", gvk), err)
+		}
+	}
+	return nil
+}
+
+// admissionContext returns a context carrying the admission request, which could be
+// retrieved by the webhooks via admission.RequestFromContext.
+func admissionContext(ctx context.Context, gvk schema.GroupVersionKind, obj client.Object, operation admissionv1.Operation) context.Context {
+	return admission.NewContextWithRequest(ctx, admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			UID:       uuid.NewUUID(),
+			Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+			Name:      obj.GetName(),
+			Namespace: obj.GetNamespace(),
+			Operation: operation,
+		},
+	})
+}
+
+// denied converts the error returned by a webhook into the one reported by the API
+// server. A webhook error without an API status is a plain denial (403 Forbidden).
+func denied(name string, err error) error {
+	status := metav1.Status{
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: err.Error(),
+	}
+	var apiStatus k8serr.APIStatus
+	if errors.As(err, &apiStatus) {
+		status = apiStatus.Status()
+	}
+	status.Status = metav1.StatusFailure
+	status.Message = fmt.Sprintf("admission webhook %q denied the request: %s", name, status.Message)
+	return &k8serr.StatusError{ErrStatus: status}
+}
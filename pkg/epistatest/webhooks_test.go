@@ -0,0 +1,185 @@
+package epistatest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// widgetWebhook defaults the widget color to blue, recording the admission operation
+// in the admitted annotation. Big widgets cannot be created, shrunk, or deleted when
+// protected.
+type widgetWebhook struct{}
+
+func (widgetWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	w := obj.(*Widget)
+	if w.Spec.Color == "" {
+		w.Spec.Color = "blue"
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if w.Annotations == nil {
+		w.Annotations = map[string]string{}
+	}
+	w.Annotations["admitted"] = string(req.Operation)
+	return nil
+}
+
+func (widgetWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	if obj.(*Widget).Spec.Size > 100 {
+		return nil, fmt.Errorf("size too large")
+	}
+	return nil, nil
+}
+
+func (widgetWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	old, w := oldObj.(*Widget), newObj.(*Widget)
+	if w.Spec.Size < old.Spec.Size {
+		return nil, k8serr.NewInvalid(widgetGV.WithKind("Widget").GroupKind(), w.Name, field.ErrorList{
+			field.Invalid(field.NewPath("spec", "size"), w.Spec.Size, "size cannot be decreased"),
+		})
+	}
+	return nil, nil
+}
+
+func (widgetWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	if _, found := obj.(*Widget).Annotations["protected"]; found {
+		return nil, fmt.Errorf("widget is protected")
+	}
+	return nil, nil
+}
+
+var widgetWebhooks = Webhook{For: &Widget{}, Defaulter: widgetWebhook{}, Validator: widgetWebhook{}}
+
+// WidgetCleaner deletes the expired widgets.
+type WidgetCleaner struct {
+	client.Client
+}
+
+func (c WidgetCleaner) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	w := &Widget{}
+	if err := c.Get(ctx, req.NamespacedName, w); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if _, found := w.Annotations["expired"]; !found {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, c.Delete(ctx, w)
+}
+
+func TestWebhooks(t *testing.T) {
+	cases := []testCase{
+		{
+			name: "defaulting",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithWebhooks(widgetWebhooks).
+				SetupObjects(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Color == "blue" && obj.Annotations["admitted"] == "CREATE"
+				}),
+		},
+		{
+			name: "defaulting on patch",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithWebhooks(widgetWebhooks).
+				SetupObjects(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(func(c client.Client, obj *Widget) {
+					original := obj.DeepCopyObject().(*Widget)
+					obj.Spec.Color = ""
+					_ = c.Patch(context.Background(), obj, client.MergeFrom(original))
+				}).
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Color == "blue" && obj.Annotations["admitted"] == "UPDATE" && obj.Generation == 1
+				}),
+		},
+		{
+			name: "schema defaulting before the webhooks",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithCRDs("testdata").
+				WithWebhooks(widgetWebhooks).
+				SetupObjects(widget(1)).
+				NextRequest("w0", "ns").
+				ReconcileUntil(func(client client.Client, obj *Widget) bool {
+					return obj.Spec.Color == "red" && obj.Annotations["admitted"] == "CREATE" && obj.Status.Phase == "Ready"
+				}),
+		},
+		{
+			name: "schema validation before the webhooks",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithCRDs("testdata").
+				WithWebhooks(widgetWebhooks).
+				SetupObjects(widget(2)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(resize(1), "shrink").
+				ReconcileUntilIdle(),
+			expectedError: "`shrink` failure, Widget.test.epistatest.io \"w0\" is invalid: spec: Invalid value: \"object\": size cannot be decreased",
+		},
+		{
+			name: "setup denied",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithWebhooks(widgetWebhooks).
+				SetupObjects(widget(101)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle(),
+			expectedError: "admission webhook \"validate-test-epistatest-io-v1-widget\" denied the request: size too large",
+		},
+		{
+			name: "update denied",
+			testCase: New[WidgetController, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithWebhooks(Webhook{For: &Widget{}, Validator: widgetWebhook{}, Name: "vwidget.kb.io"}).
+				SetupObjects(widget(2)).
+				NextRequest("w0", "ns").
+				ReconcileUntilIdle().
+				Then(resize(1), "shrink").
+				ReconcileUntilIdle(),
+			expectedError: "`shrink` failure, admission webhook \"vwidget.kb.io\" denied the request: Widget.test.epistatest.io \"w0\" is invalid: spec.size: Invalid value: 1: size cannot be decreased",
+		},
+		{
+			name: "deletion",
+			testCase: New[WidgetCleaner, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithWebhooks(widgetWebhooks).
+				SetupObjects(widget(1, "expired", "true")).
+				NextRequest("w0", "ns").
+				ExpectDeleted(),
+		},
+		{
+			name: "deletion denied to the reconciler",
+			testCase: New[WidgetCleaner, *Widget]().
+				WithSchemes(addWidgetsToScheme).
+				WithWebhooks(widgetWebhooks).
+				SetupObjects(widget(1, "expired", "true", "protected", "true")).
+				NextRequest("w0", "ns").
+				ExpectError(k8serr.IsForbidden),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			switch tc.testCase.(type) {
+			case *scenario[WidgetController, *Widget]:
+				testScenario[WidgetController, *Widget](t, tc)
+			default:
+				testScenario[WidgetCleaner, *Widget](t, tc)
+			}
+		})
+	}
+}
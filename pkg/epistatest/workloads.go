@@ -0,0 +1,388 @@
+package epistatest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// DeploymentSimulator mimics the deployment controller: for every Deployment, a
+// ReplicaSet is created for its current pod template (scaling down the previous ones),
+// and the Deployment status reflects the status of the ReplicaSet. Usually enabled
+// together with the ReplicaSetSimulator and the PodSimulator.
+func DeploymentSimulator() Simulator {
+	return &deploymentSimulator{}
+}
+
+type deploymentSimulator struct {
+	client client.Client
+}
+
+func (ds *deploymentSimulator) AddToScheme(s *runtime.Scheme) error {
+	return appsv1.AddToScheme(s)
+}
+
+func (ds *deploymentSimulator) SetupWithManager(mgr manager.Manager) error {
+	ds.client = mgr.GetClient()
+	return builder.ControllerManagedBy(mgr).
+		Named("deployment-simulator").
+		For(&appsv1.Deployment{}).
+		Owns(&appsv1.ReplicaSet{}).
+		Complete(ds)
+}
+
+func (ds *deploymentSimulator) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	deploy := &appsv1.Deployment{}
+	if err := ds.client.Get(ctx, req.NamespacedName, deploy); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if deploy.DeletionTimestamp != nil {
+		return reconcile.Result{}, nil
+	}
+
+	hash := podTemplateHash(deploy.Spec.Template)
+	rsName := fmt.Sprintf("%s-%s", deploy.Name, hash)
+
+	// The old ReplicaSets are immediately scaled down (recreate strategy).
+	rsList := &appsv1.ReplicaSetList{}
+	if err := ds.client.List(ctx, rsList, client.InNamespace(deploy.Namespace)); err != nil {
+		return reconcile.Result{}, err
+	}
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		if rs.Name == rsName || !metav1.IsControlledBy(rs, deploy) || ptr.Deref(rs.Spec.Replicas, 1) == 0 {
+			continue
+		}
+		rs.Spec.Replicas = ptr.To(int32(0))
+		if err := ds.client.Update(ctx, rs); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: rsName, Namespace: deploy.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, ds.client, rs, func() error {
+		template := deploy.Spec.Template.DeepCopy()
+		if template.Labels == nil {
+			template.Labels = map[string]string{}
+		}
+		template.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = hash
+		rs.Labels = template.Labels
+		rs.Spec.Replicas = ptr.To(ptr.Deref(deploy.Spec.Replicas, 1))
+		rs.Spec.Selector = deploy.Spec.Selector
+		rs.Spec.Template = *template
+		return controllerutil.SetControllerReference(deploy, rs, ds.client.Scheme())
+	}); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	replicas := ptr.Deref(deploy.Spec.Replicas, 1)
+	status := appsv1.DeploymentStatus{
+		ObservedGeneration:  deploy.Generation,
+		Replicas:            rs.Status.Replicas,
+		UpdatedReplicas:     rs.Status.Replicas,
+		ReadyReplicas:       rs.Status.ReadyReplicas,
+		AvailableReplicas:   rs.Status.AvailableReplicas,
+		UnavailableReplicas: max(replicas-rs.Status.AvailableReplicas, 0),
+		Conditions: []appsv1.DeploymentCondition{
+			{
+				Type:   appsv1.DeploymentAvailable,
+				Status: corev1.ConditionFalse,
+				Reason: "MinimumReplicasUnavailable",
+			},
+			{
+				Type:   appsv1.DeploymentProgressing,
+				Status: corev1.ConditionTrue,
+				Reason: "NewReplicaSetAvailable",
+			},
+		},
+	}
+	if rs.Status.AvailableReplicas >= replicas {
+		status.Conditions[0].Status = corev1.ConditionTrue
+		status.Conditions[0].Reason = "MinimumReplicasAvailable"
+	}
+	if equality.Semantic.DeepEqual(deploy.Status, status) {
+		return reconcile.Result{}, nil
+	}
+	deploy.Status = status
+	return reconcile.Result{}, ds.client.Status().Update(ctx, deploy)
+}
+
+// podTemplateHash returns a short hash identifying the pod template.
+func podTemplateHash(template corev1.PodTemplateSpec) string {
+	data, _ := json.Marshal(template)
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// ReplicaSetSimulator mimics the replicaset controller: for every ReplicaSet, the
+// pods are created or deleted to match the desired replicas, and the ReplicaSet status
+// reflects the pods readiness. Pods are named after the ReplicaSet, with a sequential
+// suffix. Usually enabled together with the PodSimulator, to make the pods ready.
+func ReplicaSetSimulator() Simulator {
+	return &replicaSetSimulator{}
+}
+
+type replicaSetSimulator struct {
+	client client.Client
+}
+
+func (rss *replicaSetSimulator) AddToScheme(s *runtime.Scheme) error {
+	if err := corev1.AddToScheme(s); err != nil {
+		return err
+	}
+	return appsv1.AddToScheme(s)
+}
+
+func (rss *replicaSetSimulator) SetupWithManager(mgr manager.Manager) error {
+	rss.client = mgr.GetClient()
+	return builder.ControllerManagedBy(mgr).
+		Named("replicaset-simulator").
+		For(&appsv1.ReplicaSet{}).
+		Owns(&corev1.Pod{}).
+		Complete(rss)
+}
+
+func (rss *replicaSetSimulator) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	rs := &appsv1.ReplicaSet{}
+	if err := rss.client.Get(ctx, req.NamespacedName, rs); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if rs.DeletionTimestamp != nil {
+		return reconcile.Result{}, nil
+	}
+
+	pods, err := ownedPods(ctx, rss.client, rs)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Scale up, by filling the missing indexes.
+	replicas := int(ptr.Deref(rs.Spec.Replicas, 1))
+	names := map[string]bool{}
+	for _, pod := range pods {
+		names[pod.Name] = true
+	}
+	for i := 0; len(pods) < replicas; i++ {
+		name := fmt.Sprintf("%s-%d", rs.Name, i)
+		if names[name] {
+			continue
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   rs.Namespace,
+				Labels:      rs.Spec.Template.Labels,
+				Annotations: rs.Spec.Template.Annotations,
+			},
+			Spec: rs.Spec.Template.Spec,
+		}
+		if err := controllerutil.SetControllerReference(rs, pod, rss.client.Scheme()); err != nil {
+			return reconcile.Result{}, err
+		}
+		if err := rss.client.Create(ctx, pod); err != nil {
+			return reconcile.Result{}, err
+		}
+		pods = append(pods, *pod)
+	}
+
+	// Scale down, by removing the latest pods.
+	for len(pods) > replicas {
+		last := &pods[len(pods)-1]
+		if err := rss.client.Delete(ctx, last); client.IgnoreNotFound(err) != nil {
+			return reconcile.Result{}, err
+		}
+		pods = pods[:len(pods)-1]
+	}
+
+	status := appsv1.ReplicaSetStatus{
+		Replicas:           int32(len(pods)),
+		ObservedGeneration: rs.Generation,
+	}
+	for _, pod := range pods {
+		if podReady(&pod) {
+			status.ReadyReplicas++
+			status.AvailableReplicas++
+		}
+	}
+	status.FullyLabeledReplicas = status.Replicas
+	if equality.Semantic.DeepEqual(rs.Status, status) {
+		return reconcile.Result{}, nil
+	}
+	rs.Status = status
+	return reconcile.Result{}, rss.client.Status().Update(ctx, rs)
+}
+
+// JobSimulator mimics the job controller: for every Job, the pods are created one
+// at a time, and they are immediately considered completed successfully, until the
+// required completions are reached. The Job is then marked as complete. The start and
+// completion times are taken from the scenario clock.
+func JobSimulator() Simulator {
+	return &jobSimulator{}
+}
+
+type jobSimulator struct {
+	client client.Client
+	clock  clock.PassiveClock
+}
+
+func (js *jobSimulator) useClock(clock clock.PassiveClock) {
+	js.clock = clock
+}
+
+func (js *jobSimulator) AddToScheme(s *runtime.Scheme) error {
+	if err := corev1.AddToScheme(s); err != nil {
+		return err
+	}
+	return batchv1.AddToScheme(s)
+}
+
+func (js *jobSimulator) SetupWithManager(mgr manager.Manager) error {
+	js.client = mgr.GetClient()
+	return builder.ControllerManagedBy(mgr).
+		Named("job-simulator").
+		For(&batchv1.Job{}).
+		Owns(&corev1.Pod{}).
+		Complete(js)
+}
+
+func (js *jobSimulator) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	job := &batchv1.Job{}
+	if err := js.client.Get(ctx, req.NamespacedName, job); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if job.DeletionTimestamp != nil || jobFinished(job) {
+		return reconcile.Result{}, nil
+	}
+
+	pods, err := ownedPods(ctx, js.client, job)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// The pods are completed as soon as they are observed.
+	succeeded := int32(0)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			pod.Status.Phase = corev1.PodSucceeded
+			if err := js.client.Status().Update(ctx, pod); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		if pod.Status.Phase == corev1.PodSucceeded {
+			succeeded++
+		}
+	}
+
+	original := job.Status.DeepCopy()
+	now := metav1.NewTime(js.clock.Now())
+	if job.Status.StartTime == nil {
+		job.Status.StartTime = &now
+	}
+	completions := ptr.Deref(job.Spec.Completions, 1)
+	if succeeded < completions {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("%s-%d", job.Name, len(pods)),
+				Namespace:   job.Namespace,
+				Labels:      job.Spec.Template.Labels,
+				Annotations: job.Spec.Template.Annotations,
+			},
+			Spec: job.Spec.Template.Spec,
+		}
+		if err := controllerutil.SetControllerReference(job, pod, js.client.Scheme()); err != nil {
+			return reconcile.Result{}, err
+		}
+		if err := js.client.Create(ctx, pod); err != nil && !k8serr.IsAlreadyExists(err) {
+			return reconcile.Result{}, err
+		}
+		job.Status.Active = 1
+	} else {
+		job.Status.Active = 0
+		job.Status.CompletionTime = &now
+		job.Status.Conditions = append(job.Status.Conditions,
+			batchv1.JobCondition{
+				Type:               batchv1.JobSuccessCriteriaMet,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: now,
+			},
+			batchv1.JobCondition{
+				Type:               batchv1.JobComplete,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: now,
+			})
+	}
+	job.Status.Succeeded = succeeded
+	job.Status.Ready = ptr.To(int32(0))
+	if equality.Semantic.DeepEqual(*original, job.Status) {
+		return reconcile.Result{}, nil
+	}
+	return reconcile.Result{}, js.client.Status().Update(ctx, job)
+}
+
+func jobFinished(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// ownedPods returns the pods controlled by the owner, sorted by creation.
+func ownedPods(ctx context.Context, c client.Client, owner metav1.Object) ([]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	if err := c.List(ctx, podList, client.InNamespace(owner.GetNamespace())); err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if metav1.IsControlledBy(&pod, owner) && pod.DeletionTimestamp == nil {
+			pods = append(pods, pod)
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return podCreatedBefore(&pods[i], &pods[j])
+	})
+	return pods, nil
+}
+
+// podCreatedBefore orders the pods by creation timestamp. The pods created at the same
+// time are ordered by the numeric suffix of their name, as assigned by the simulators.
+func podCreatedBefore(a, b *corev1.Pod) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	aIndex, aErr := podIndex(a.Name)
+	bIndex, bErr := podIndex(b.Name)
+	if aErr == nil && bErr == nil && aIndex != bIndex {
+		return aIndex < bIndex
+	}
+	return a.Name < b.Name
+}
+
+// podIndex returns the numeric suffix of the pod name.
+func podIndex(name string) (int, error) {
+	return strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+}